@@ -0,0 +1,110 @@
+package main
+
+import (
+	"time"
+)
+
+// AmortizedCost returns the cost of the line item with upfront commitment fees
+// spread across the billing period. Columns take precedence as follows:
+//
+//   - a non-zero amortized cost provided directly by an export with a
+//     cost_type column is always used
+//   - RIFee line items use the unused part of the reservation,
+//     reservation/UnusedAmortizedUpfrontFeeForBillingPeriod plus
+//     reservation/UnusedRecurringFee, rather than the payment in UnblendedCost
+//   - DiscountedUsage line items use reservation/EffectiveCost, which holds
+//     the upfront and recurring fees of the used part of the reservation
+//   - SavingsPlanCoveredUsage line items use savingsPlan/SavingsPlanEffectiveCost
+//   - all other line items, and DiscountedUsage and SavingsPlanCoveredUsage
+//     line items whose column is absent or zero, use UnblendedCost
+func (l *LineItem) AmortizedCost() float64 {
+	if l.ReportedAmortizedCost != 0 {
+		return l.ReportedAmortizedCost
+	}
+	switch l.LineItemType {
+	case "RIFee":
+		// the used part is already counted by the DiscountedUsage line items
+		return l.UnusedAmortizedUpfrontFeeForBillingPeriod + l.UnusedRecurringFee
+	case "DiscountedUsage":
+		if l.ReservationEffectiveCost != 0 {
+			return l.ReservationEffectiveCost
+		}
+	case "SavingsPlanCoveredUsage":
+		if l.SavingsPlanEffectiveCost != 0 {
+			return l.SavingsPlanEffectiveCost
+		}
+	}
+	return l.UnblendedCost
+}
+
+// AmortizedTotal returns the total amortized cost of the line items in the
+// window. See LineItem.AmortizedCost for how each line item is amortized.
+func (r Report) AmortizedTotal(s, e time.Time) float64 {
+	var total float64
+	for _, item := range r.aggregateItems(s, e) {
+		total += item.AmortizedCost()
+	}
+	return total
+}
+
+// AllocateReservation distributes the amortized cost of the reserved instance
+// identified by arn across the accounts whose usage it covered in the window.
+// The reservation's cost is the amortized cost of its RIFee and DiscountedUsage
+// line items, covering its upfront and recurring fees whether used or not, and
+// each account's share is proportional to the UsageAmount of its
+// DiscountedUsage line items covered by the reservation.
+func (r Report) AllocateReservation(arn string, s, e time.Time) map[string]float64 {
+	var cost, totalUsage float64
+	usage := make(map[string]float64)
+	for _, item := range r.FilterByTime(s, e) {
+		if item.ReservationARN != arn {
+			continue
+		}
+		switch item.LineItemType {
+		case "RIFee":
+			cost += item.AmortizedCost()
+		case "DiscountedUsage":
+			cost += item.AmortizedCost()
+			usage[item.UsageAccountID] += item.UsageAmount
+			totalUsage += item.UsageAmount
+		}
+	}
+
+	alloc := make(map[string]float64, len(usage))
+	if totalUsage == 0 {
+		return alloc
+	}
+	for account, amount := range usage {
+		alloc[account] = cost * amount / totalUsage
+	}
+	return alloc
+}
+
+// CommitmentCoverage returns the fraction of eligible usage cost in the window
+// covered by Reserved Instances and Savings Plans. Usage, DiscountedUsage and
+// SavingsPlanCoveredUsage line items are eligible, of which DiscountedUsage
+// (covered by an RI) and SavingsPlanCoveredUsage are covered. Fees, taxes,
+// credits and other line item types are ignored. Each line item is weighed by
+// its pricing/publicOnDemandCost so that covered usage is compared at the same
+// rate as uncovered usage, falling back to UnblendedCost when absent. Returns
+// 0 if there is no eligible cost.
+func (r Report) CommitmentCoverage(s, e time.Time) float64 {
+	var covered, eligible float64
+	for _, item := range r.FilterByTime(s, e) {
+		cost := item.PublicOnDemandCost
+		if cost == 0 {
+			cost = item.UnblendedCost
+		}
+		switch item.LineItemType {
+		case "DiscountedUsage", "SavingsPlanCoveredUsage":
+			covered += cost
+			eligible += cost
+		case "Usage":
+			eligible += cost
+		}
+	}
+	if eligible == 0 {
+		return 0
+	}
+	return covered / eligible
+}
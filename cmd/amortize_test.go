@@ -0,0 +1,102 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// riFeeItem returns an RIFee line item in the month starting at month with
+// the payment made that month and the upfront and recurring fees of the
+// reservation left unused by its DiscountedUsage line items
+func riFeeItem(uid uint64, month time.Time, payment, unusedUpfront, unusedRecurring float64) *LineItem {
+	l := testItem(uid, int(month.Sub(testStart)/time.Hour), "AmazonEC2", payment)
+	l.LineItemType = "RIFee"
+	l.UnusedAmortizedUpfrontFeeForBillingPeriod = unusedUpfront
+	l.UnusedRecurringFee = unusedRecurring
+	return l
+}
+
+// discountedItem returns a DiscountedUsage line item in the month starting at
+// month whose effective cost holds the fees of the reservation it used
+func discountedItem(uid uint64, month time.Time, effective float64) *LineItem {
+	l := testItem(uid, int(month.Sub(testStart)/time.Hour), "AmazonEC2", 0)
+	l.LineItemType = "DiscountedUsage"
+	l.ReservationEffectiveCost = effective
+	return l
+}
+
+func TestAmortizedTotalUpfrontRI(t *testing.T) {
+	// an all upfront RI is paid for in full by the first RIFee line item while
+	// every month carries its amortized share of the upfront fee, a quarter of
+	// which goes unused
+	june := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+	july := time.Date(2020, 7, 1, 0, 0, 0, 0, time.UTC)
+	r := newTestReport(
+		riFeeItem(1, testStart, 1200, 25, 0),
+		discountedItem(2, testStart, 75),
+		riFeeItem(3, june, 0, 25, 0),
+		discountedItem(4, june, 75),
+	)
+
+	// each window ends just before the next month starts
+	months := []struct {
+		s, e             time.Time
+		lumpy, amortized float64
+	}{
+		{testStart, june.Add(-time.Second), 1200, 100},
+		{june, july.Add(-time.Second), 0, 100},
+	}
+	for _, m := range months {
+		if lumpy := r.GroupBy([]string{"lineItem/LineItemType"}, m.s, m.e)["RIFee"]; lumpy != m.lumpy {
+			t.Errorf("expected an unblended total of %v from %v but got %v", m.lumpy, m.s, lumpy)
+		}
+		if amortized := r.AmortizedTotal(m.s, m.e); amortized != m.amortized {
+			t.Errorf("expected an amortized total of %v from %v but got %v", m.amortized, m.s, amortized)
+		}
+	}
+}
+
+func TestAmortizedTotalPaymentOptions(t *testing.T) {
+	// a quarter of each reservation goes unused, leaving the rest of its
+	// upfront and recurring fees in the effective cost of its usage
+	tests := []struct {
+		option             string
+		upfront, recurring float64
+	}{
+		{"All Upfront", 100, 0},
+		{"Partial Upfront", 50, 40},
+		{"No Upfront", 0, 90},
+	}
+	for _, test := range tests {
+		r := newTestReport(
+			riFeeItem(1, testStart, test.recurring, test.upfront/4, test.recurring/4),
+			discountedItem(2, testStart, (test.upfront+test.recurring)*3/4),
+		)
+		if amortized, expected := r.AmortizedTotal(testStart, testEnd), test.upfront+test.recurring; amortized != expected {
+			t.Errorf("expected an amortized total of %v for %s but got %v", expected, test.option, amortized)
+		}
+	}
+}
+
+func TestAllocateReservation(t *testing.T) {
+	// a partial upfront RI with a 50 upfront and 40 recurring fee of which
+	// two accounts use three quarters in a 2:1 ratio
+	arn := "arn:aws:ec2:us-east-1:111111111111:reserved-instances/ri"
+	fee := riFeeItem(1, testStart, 40, 12.5, 10)
+	fee.ReservationARN = arn
+	heavy := discountedItem(2, testStart, 45)
+	heavy.ReservationARN, heavy.UsageAccountID, heavy.UsageAmount = arn, "111111111111", 2
+	light := discountedItem(3, testStart, 22.5)
+	light.ReservationARN, light.UsageAccountID, light.UsageAmount = arn, "222222222222", 1
+	// usage covered by another reservation is left out
+	other := discountedItem(4, testStart, 10)
+	other.UsageAccountID, other.UsageAmount = "333333333333", 1
+	r := newTestReport(fee, heavy, light, other)
+
+	alloc := r.AllocateReservation(arn, testStart, testEnd)
+	expected := map[string]float64{"111111111111": 60, "222222222222": 30}
+	if !reflect.DeepEqual(alloc, expected) {
+		t.Errorf("expected the upfront and recurring fees to be allocated as %v but got %v", expected, alloc)
+	}
+}
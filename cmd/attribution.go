@@ -0,0 +1,152 @@
+package main
+
+import (
+	"time"
+)
+
+// OwnerSuggestion is a best effort owner for an untagged resource
+type OwnerSuggestion struct {
+	Owner   string // most common tag value among tagged resources in the account
+	Votes   int    // number of tagged resources in the account with Owner
+	Account string
+	Cost    float64 // UnblendedCost of the untagged resource in the window
+}
+
+// SuggestOwners guesses a value of tagKey, such as resourceTags/user:Team, for
+// every resource in the window that lacks it. Each tagged resource votes for
+// its tag value within its UsageAccountId and an untagged resource is assigned
+// the value with the most votes in its account, ties broken by value. Untagged
+// resources in accounts without any tagged resources are omitted.
+func (r Report) SuggestOwners(tagKey string, s, e time.Time) map[string]OwnerSuggestion {
+	// account -> tag value -> tagged resources
+	tagged := make(map[string]map[string]map[string]struct{})
+	untagged := make(map[string]*OwnerSuggestion)
+	for _, item := range r.FilterByTime(s, e) {
+		if item.ResourceID == "" {
+			continue
+		}
+		owner := item.Tags[tagKey]
+		if owner == "" {
+			sug, exists := untagged[item.ResourceID]
+			if !exists {
+				sug = &OwnerSuggestion{Account: item.UsageAccountID}
+				untagged[item.ResourceID] = sug
+			}
+			sug.Cost += item.UnblendedCost
+			continue
+		}
+
+		if _, exists := tagged[item.UsageAccountID]; !exists {
+			tagged[item.UsageAccountID] = make(map[string]map[string]struct{})
+		}
+		if _, exists := tagged[item.UsageAccountID][owner]; !exists {
+			tagged[item.UsageAccountID][owner] = make(map[string]struct{})
+		}
+		tagged[item.UsageAccountID][owner][item.ResourceID] = struct{}{}
+	}
+
+	res := make(map[string]OwnerSuggestion)
+	for resourceID, sug := range untagged {
+		// a resource tagged on some line items is not untagged
+		if isTagged(tagged[sug.Account], resourceID) {
+			continue
+		}
+		for owner, resources := range tagged[sug.Account] {
+			votes := len(resources)
+			if votes > sug.Votes || (votes == sug.Votes && owner < sug.Owner) {
+				sug.Owner = owner
+				sug.Votes = votes
+			}
+		}
+		if sug.Votes > 0 {
+			res[resourceID] = *sug
+		}
+	}
+	return res
+}
+
+func isTagged(owners map[string]map[string]struct{}, resourceID string) bool {
+	for _, resources := range owners {
+		if _, exists := resources[resourceID]; exists {
+			return true
+		}
+	}
+	return false
+}
+
+// AttributionRules decide which team a line item is charged to. A line item
+// is attributed by the first of TagKeys it has a value for, or otherwise by
+// its UsageAccountId's entry in Accounts.
+type AttributionRules struct {
+	TagKeys  []string          // e.g. resourceTags/user:Team
+	Accounts map[string]string // UsageAccountId to team
+}
+
+// Attribute returns the team the line item is attributed to or false if no
+// rule matches
+func (a AttributionRules) Attribute(l *LineItem) (string, bool) {
+	for _, key := range a.TagKeys {
+		if team := l.Tags[key]; team != "" {
+			return team, true
+		}
+	}
+	team, exists := a.Accounts[l.UsageAccountID]
+	return team, exists
+}
+
+// AttributionGap is the spend that no attribution rule assigns to a team
+type AttributionGap struct {
+	Total        float64
+	Unattributed float64
+	Share        float64            // Unattributed divided by Total, 0 if Total is 0
+	ByProduct    map[string]float64 // unattributed cost per ProductCode
+}
+
+// AttributionGap sums the UnblendedCost in the window that the rules cannot
+// attribute to any team along with its share of the total and a breakdown by
+// ProductCode
+func (r Report) AttributionGap(rules AttributionRules, s, e time.Time) AttributionGap {
+	gap := AttributionGap{ByProduct: make(map[string]float64)}
+	for _, item := range r.aggregateItems(s, e) {
+		gap.Total += item.UnblendedCost
+		if _, ok := rules.Attribute(item); ok {
+			continue
+		}
+		gap.Unattributed += item.UnblendedCost
+		gap.ByProduct[item.ProductCode] += item.UnblendedCost
+	}
+	if gap.Total != 0 {
+		gap.Share = gap.Unattributed / gap.Total
+	}
+	return gap
+}
+
+// Chargeback sums the UnblendedCost in the window per value of tagKey, such as
+// resourceTags/user:Team, and spreads the cost of line items without the tag
+// across the values in proportion to their tagged cost, so that the result
+// sums to the total spend. If nothing in the window is tagged the untagged
+// cost cannot be spread and is returned under the empty value.
+func (r Report) Chargeback(tagKey string, s, e time.Time) map[string]float64 {
+	res := make(map[string]float64)
+	var tagged, untagged float64
+	for _, item := range r.aggregateItems(s, e) {
+		team := item.Tags[tagKey]
+		if team == "" {
+			untagged += item.UnblendedCost
+			continue
+		}
+		res[team] += item.UnblendedCost
+		tagged += item.UnblendedCost
+	}
+
+	if tagged == 0 {
+		if untagged != 0 {
+			res[""] = untagged
+		}
+		return res
+	}
+	for team, cost := range res {
+		res[team] = cost + untagged*cost/tagged
+	}
+	return res
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// queryCache memoizes grouped query results. It is safe for concurrent use by
+// queries but the report must not be mutated concurrently with queries.
+type queryCache struct {
+	mu      sync.Mutex
+	results map[queryKey]map[string]float64
+}
+
+type queryKey struct {
+	fields string
+	metric Metric
+	// seconds and nanoseconds as UnixNano overflows outside years 1678 to 2262
+	startSec, startNsec int64
+	endSec, endNsec     int64
+	// options which change the result of a query
+	highPrecision bool
+	excludeTax    bool
+	positiveOnly  bool
+	normalizeAZ   bool
+	inclusiveEnd  bool
+	// derived fields registered at the time of the query
+	fieldsGen uint64
+}
+
+// EnableCache memoizes GroupBy results keyed by the query parameters so that
+// repeated identical queries skip the scan. Cached results are discarded
+// whenever the report is mutated through AddLineItem or a derived field is
+// registered. Copies of the report made after enabling share its cache.
+func (r *Report) EnableCache() {
+	r.cache = &queryCache{results: make(map[queryKey]map[string]float64)}
+}
+
+func (r Report) queryKey(fields []string, s, e time.Time, metric Metric) queryKey {
+	return queryKey{
+		fields:        strings.Join(fields, "\x00"),
+		metric:        metric,
+		startSec:      s.Unix(),
+		startNsec:     int64(s.Nanosecond()),
+		endSec:        e.Unix(),
+		endNsec:       int64(e.Nanosecond()),
+		highPrecision: r.HighPrecision,
+		excludeTax:    r.ExcludeTax,
+		positiveOnly:  r.PositiveOnly,
+		normalizeAZ:   r.NormalizeAZ,
+		inclusiveEnd:  r.InclusiveEnd,
+		fieldsGen:     derivedGeneration(),
+	}
+}
+
+// get returns a copy of the cached result for key
+func (c *queryCache) get(key queryKey) (map[string]float64, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	res, exists := c.results[key]
+	if !exists {
+		return nil, false
+	}
+	return copyResult(res), true
+}
+
+// put stores a copy of the result for key
+func (c *queryCache) put(key queryKey, res map[string]float64) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	c.results[key] = copyResult(res)
+	c.mu.Unlock()
+}
+
+// invalidate discards all cached results
+func (c *queryCache) invalidate() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	c.results = make(map[queryKey]map[string]float64)
+	c.mu.Unlock()
+}
+
+func copyResult(res map[string]float64) map[string]float64 {
+	c := make(map[string]float64, len(res))
+	for k, v := range res {
+		c[k] = v
+	}
+	return c
+}
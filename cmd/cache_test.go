@@ -0,0 +1,36 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCacheRegisterField(t *testing.T) {
+	a, b := testItem(1, 0, "AmazonEC2", 1), testItem(2, 0, "AmazonEC2", 2)
+	a.UsageAccountID, b.UsageAccountID = "111", "222"
+	r := newTestReport(a, b)
+	r.EnableCache()
+
+	RegisterProdAccounts([]string{"111", "222"})
+	if res := r.GroupBy([]string{IsProdField}, testStart, testEnd); res["true"] != 3 {
+		t.Fatalf("expected 3 in prod but got %v", res)
+	}
+	RegisterProdAccounts([]string{"111"})
+	if res := r.GroupBy([]string{IsProdField}, testStart, testEnd); res["true"] != 1 || res["false"] != 2 {
+		t.Errorf("expected the cache to be invalidated by re-registering %s but got %v", IsProdField, res)
+	}
+}
+
+func TestCacheUnboundedEnd(t *testing.T) {
+	r := newTestReport(testItem(1, 0, "AmazonEC2", 1))
+	r.EnableCache()
+
+	// UnixNano wraps past 2262 so maxTime shares its value with this end
+	wrapped := time.Unix(0, maxTime.UnixNano()).UTC()
+	if res := r.GroupBy([]string{"lineItem/ProductCode"}, testStart, wrapped); len(res) != 0 {
+		t.Fatalf("expected no line items before %v but got %v", wrapped, res)
+	}
+	if res := r.GroupBy([]string{"lineItem/ProductCode"}, testStart, maxTime); res["AmazonEC2"] != 1 {
+		t.Errorf("expected 1 for AmazonEC2 up to %v but got %v", maxTime, res)
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import "strings"
+
+// AbsentValue is the grouped value used for a field whose column was not
+// present in any parsed file, distinguishing it from a column that was present
+// but empty for a line item
+const AbsentValue = "<absent>"
+
+// addColumns records the columns present in a parsed file's header
+func (r *Report) addColumns(headers []string) {
+	if r.columns == nil {
+		r.columns = make(map[string]struct{}, len(headers))
+	}
+	for _, header := range headers {
+		r.columns[header] = struct{}{}
+	}
+}
+
+// Columns returns the sorted names of the columns present in the files parsed
+// into the report. Reports built only through AddLineItem have no columns.
+func (r Report) Columns() []string {
+	return sortedKeys(r.columns)
+}
+
+// HasColumn returns whether the column was present in a parsed file
+func (r Report) HasColumn(column string) bool {
+	_, exists := r.columns[column]
+	return exists
+}
+
+// fieldValue returns the line item's value for field, substituting AbsentValue
+// when the field is a CUR column missing from the parsed files
+func (r Report) fieldValue(l *LineItem, field string) (string, bool) {
+	if r.NormalizeAZ && field == "lineItem/AvailabilityZone" {
+		return normalizeAZ(l), true
+	}
+	val, ok := l.FieldValue(field)
+	if !ok || r.columns == nil {
+		return val, ok
+	}
+	if _, derived := derivedField(field); derived {
+		return val, ok
+	}
+	if strings.HasPrefix(field, tagPrefix) && r.HasColumn(cur2TagsColumn) {
+		return val, ok
+	}
+	if !r.HasColumn(field) {
+		return AbsentValue, true
+	}
+	return val, ok
+}
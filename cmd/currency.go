@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Convert multiplies the costs and rates of every line item by the rate for
+// its CurrencyCode and sets the CurrencyCode to base, so that totals across a
+// billing family with several currencies are meaningful. Line items already
+// in base are left as is. An error is returned without modifying the report if
+// any currency is missing a rate. Line items are converted in place, so Clone
+// the report first to keep the original costs.
+func (r *Report) Convert(rates map[string]float64, base string) error {
+	for _, items := range r.LineItems {
+		for _, item := range items {
+			if item.CurrencyCode == base {
+				continue
+			}
+			if _, exists := rates[item.CurrencyCode]; !exists {
+				return fmt.Errorf("No exchange rate for currency, %s, to %s", item.CurrencyCode, base)
+			}
+		}
+	}
+
+	for _, items := range r.LineItems {
+		for _, item := range items {
+			if item.CurrencyCode == base {
+				continue
+			}
+			item.convert(rates[item.CurrencyCode])
+			item.CurrencyCode = base
+		}
+	}
+	r.cache.invalidate()
+	return nil
+}
+
+// convert multiplies every monetary field of the line item by rate
+func (l *LineItem) convert(rate float64) {
+	for _, v := range []*float64{
+		&l.BlendedCost,
+		&l.BlendedRate,
+		&l.UnblendedCost,
+		&l.UnblendedRate,
+		&l.PublicOnDemandCost,
+		&l.SavingsPlanEffectiveCost,
+		&l.AmortizedUpfrontFeeForBillingPeriod,
+		&l.AmortizedUpfrontCostForUsage,
+		&l.RecurringFeeForUsage,
+		&l.ReservationEffectiveCost,
+		&l.UnusedAmortizedUpfrontFeeForBillingPeriod,
+		&l.UnusedRecurringFee,
+		&l.ReportedAmortizedCost,
+	} {
+		*v *= rate
+	}
+}
+
+// Currencies returns the sorted distinct CurrencyCodes of the line items
+func Currencies(items []*LineItem) []string {
+	set := make(map[string]struct{})
+	for _, item := range items {
+		set[item.CurrencyCode] = struct{}{}
+	}
+	codes := make([]string, 0, len(set))
+	for code := range set {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	return codes
+}
+
+// warnMixedCurrencies logs a notice if items are in more than one currency,
+// since their summed costs are meaningless until the report is converted
+func (r Report) warnMixedCurrencies(items []*LineItem) {
+	if codes := Currencies(items); len(codes) > 1 {
+		r.logf("Aggregating line items in multiple currencies, %s, use Convert to a single currency first\n", strings.Join(codes, ", "))
+	}
+}
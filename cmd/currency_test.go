@@ -0,0 +1,33 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWarnMixedCurrencies(t *testing.T) {
+	usd, eur := testItem(1, 0, "AmazonEC2", 1), testItem(2, 0, "AmazonEC2", 2)
+	eur.CurrencyCode = "EUR"
+	r := newTestReport(usd, eur)
+
+	queries := map[string]func(){
+		"GroupBy":           func() { r.GroupBy([]string{"lineItem/ProductCode"}, testStart, testEnd) },
+		"GroupByFields":     func() { r.GroupByFields([]string{"lineItem/ProductCode"}, testStart, testEnd) },
+		"GroupByTimeSeries": func() { r.GroupByTimeSeries(nil, testStart, testEnd, time.Hour) },
+		"GroupByMonth":      func() { r.GroupByMonth(nil, testStart, testEnd) },
+		"GroupByNet":        func() { r.GroupByNet(nil, testStart, testEnd) },
+		"GroupByResource":   func() { r.GroupByResource(testStart, testEnd) },
+		"Pivot":             func() { r.Pivot("lineItem/ProductCode", "lineItem/LineItemType", testStart, testEnd) },
+	}
+	for name, query := range queries {
+		var buf bytes.Buffer
+		r.Logger = log.New(&buf, "", 0)
+		query()
+		if !strings.Contains(buf.String(), "EUR, USD") {
+			t.Errorf("expected %s to warn about mixed currencies but logged %q", name, buf.String())
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ParseError is a failure to parse a single row of a CUR csv, or a whole file
+// when loading several
+type ParseError struct {
+	File string // file the row was read from when loading several files
+	Row  int    // 1-based row number, not counting the header, 0 for the whole file
+	Err  error
+}
+
+func (p ParseError) Error() string {
+	switch {
+	case p.File == "":
+		return fmt.Sprintf("Could not parse row %d, %v", p.Row, p.Err)
+	case p.Row == 0:
+		return fmt.Sprintf("Could not parse %s, %v", p.File, p.Err)
+	}
+	return fmt.Sprintf("Could not parse row %d of %s, %v", p.Row, p.File, p.Err)
+}
+
+// maxReportedErrors caps how many rows are listed in a ParseErrors message
+const maxReportedErrors = 3
+
+// ParseErrors are the malformed rows skipped while parsing a report. It is
+// returned alongside the partial report so callers can decide whether to
+// tolerate them.
+type ParseErrors []ParseError
+
+func (p ParseErrors) Error() string {
+	msgs := make([]string, 0, maxReportedErrors)
+	for i := 0; i < len(p) && i < maxReportedErrors; i++ {
+		msgs = append(msgs, p[i].Error())
+	}
+	if len(p) > maxReportedErrors {
+		msgs = append(msgs, fmt.Sprintf("and %d more", len(p)-maxReportedErrors))
+	}
+	return fmt.Sprintf("Skipped %d malformed rows, %s", len(p), strings.Join(msgs, "; "))
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseErrorsSkipRow(t *testing.T) {
+	data := testCSV(t, nil,
+		map[string]string{},
+		map[string]string{"lineItem/UnblendedCost": "not a number"},
+		map[string]string{},
+	)
+
+	r, err := NewReportFromReader(strings.NewReader(data), WithLogger(discardLogger))
+	perrs, ok := err.(ParseErrors)
+	if !ok || len(perrs) != 1 || perrs[0].Row != 2 {
+		t.Fatalf("expected row 2 to be reported as malformed but got %v", err)
+	}
+	items := r.FilterByTime(testStart, testEnd)
+	if len(items) != 2 || items[0].LineItemID != "id1" || items[1].LineItemID != "id3" {
+		t.Errorf("expected the rows around the malformed one to be loaded but got %d line items", len(items))
+	}
+
+	if _, err := NewReportFromReader(strings.NewReader(data), Strict(), WithLogger(discardLogger)); err == nil {
+		t.Error("expected Strict to fail on the malformed row")
+	} else if perr, ok := err.(ParseError); !ok || perr.Row != 2 {
+		t.Errorf("expected a ParseError for row 2 but got %v", err)
+	}
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Exporter writes the contents of a report over a time window to w.
+type Exporter interface {
+	Export(w io.Writer, r Report, s, e time.Time) error
+}
+
+// ResultsExporter is implemented by exporters of grouped costs so that results
+// grouped ahead of time, e.g. reduced by GroupByTopN or RollUpBelow, are
+// written in the same format as Export
+type ResultsExporter interface {
+	ExportResults(w io.Writer, results []GroupResult) error
+}
+
+// exporters maps an output format name to a constructor for its Exporter.
+// Exporters of grouped results group on the provided fields.
+var exporters = map[string]func(fields []string) Exporter{
+	"csv":        func(fields []string) Exporter { return CSVExporter{Fields: fields} },
+	"grafana":    func(fields []string) Exporter { return GrafanaExporter{Fields: fields, Bucket: 24 * time.Hour} },
+	"json":       func(fields []string) Exporter { return JSONExporter{Fields: fields} },
+	"ndjson":     func(fields []string) Exporter { return NDJSONExporter{} },
+	"parquet":    func(fields []string) Exporter { return ParquetExporter{Fields: fields} },
+	"prometheus": func(fields []string) Exporter { return PrometheusExporter{Fields: fields} },
+	"xlsx":       func(fields []string) Exporter { return XLSXExporter{Fields: fields} },
+}
+
+// NewExporter returns the Exporter registered under format
+func NewExporter(format string, fields []string) (Exporter, error) {
+	newExporter, exists := exporters[strings.ToLower(format)]
+	if !exists {
+		return nil, fmt.Errorf("Unsupported export format, %s", format)
+	}
+	return newExporter(fields), nil
+}
+
+// ExportFormats returns the sorted names of all registered export formats
+func ExportFormats() []string {
+	formats := make([]string, 0, len(exporters))
+	for format := range exporters {
+		formats = append(formats, format)
+	}
+	sort.Strings(formats)
+	return formats
+}
+
+// supportedFields filters out fields that cannot be grouped on so that column
+// headers line up with the grouped values
+func supportedFields(fields []string) []string {
+	var supported []string
+	for _, field := range fields {
+		if isSupportedField(field) {
+			supported = append(supported, field)
+		}
+	}
+	return supported
+}
+
+// CSVExporter writes grouped costs as CSV with one column per grouped field
+// followed by the cost
+type CSVExporter struct {
+	Fields   []string
+	Decimals int // decimal places costs are rounded to, DefaultDecimals if 0
+}
+
+func (c CSVExporter) Export(w io.Writer, r Report, s, e time.Time) error {
+	return c.ExportResults(w, r.GroupByFields(c.Fields, s, e))
+}
+
+func (c CSVExporter) ExportResults(w io.Writer, results []GroupResult) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(append(supportedFields(c.Fields), "cost")); err != nil {
+		return err
+	}
+	for _, row := range results {
+		record := append(append([]string(nil), row.Values...), FormatDecimal(row.Cost, decimals(c.Decimals)))
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// JSONExporter writes grouped costs as an indented JSON array of objects
+// keyed by field name with a cost entry
+type JSONExporter struct {
+	Fields   []string
+	Decimals int // decimal places costs are rounded to, DefaultDecimals if 0
+}
+
+func (j JSONExporter) Export(w io.Writer, r Report, s, e time.Time) error {
+	return j.ExportResults(w, r.GroupByFields(j.Fields, s, e))
+}
+
+func (j JSONExporter) ExportResults(w io.Writer, results []GroupResult) error {
+	fields := supportedFields(j.Fields)
+	out := make([]map[string]interface{}, 0, len(results))
+	for _, row := range results {
+		obj := make(map[string]interface{}, len(fields)+1)
+		for i, field := range fields {
+			obj[field] = row.Values[i]
+		}
+		obj["cost"] = RoundDecimal(row.Cost, decimals(j.Decimals))
+		out = append(out, obj)
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
+
+// decimals returns the configured number of decimal places of an exporter or
+// DefaultDecimals if unset
+func decimals(places int) int {
+	if places == 0 {
+		return DefaultDecimals
+	}
+	return places
+}
+
+// NDJSONExporter writes every line item in the window as one JSON object per
+// line
+type NDJSONExporter struct{}
+
+func (n NDJSONExporter) Export(w io.Writer, r Report, s, e time.Time) error {
+	return WriteLineItemsJSONL(w, r.FilterByTime(s, e))
+}
+
+// WriteLineItemsJSONL writes each line item, including its Bill, as one JSON
+// object per line. Times are written in RFC 3339 format.
+func WriteLineItemsJSONL(w io.Writer, items []*LineItem) error {
+	enc := json.NewEncoder(w)
+	for _, item := range items {
+		if err := enc.Encode(item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GrafanaExporter writes grouped costs bucketed over time in the Grafana JSON
+// datasource time series format with one target per group key
+type GrafanaExporter struct {
+	Fields []string
+	Bucket time.Duration // width of each datapoint, truncated relative to UTC
+}
+
+// grafanaTarget is a single Grafana time series
+type grafanaTarget struct {
+	Target     string       `json:"target"`
+	Datapoints [][2]float64 `json:"datapoints"` // [value, unix millis] pairs
+}
+
+func (g GrafanaExporter) Export(w io.Writer, r Report, s, e time.Time) error {
+	if g.Bucket <= 0 {
+		return fmt.Errorf("Invalid bucket duration, %v", g.Bucket)
+	}
+
+	series := r.GroupByTimeSeries(g.Fields, s, e, g.Bucket)
+	targets := make([]grafanaTarget, 0, len(series))
+	for key, buckets := range series {
+		t := grafanaTarget{Target: key, Datapoints: make([][2]float64, 0, len(buckets))}
+		for at, cost := range buckets {
+			t.Datapoints = append(t.Datapoints, [2]float64{cost, float64(at.UnixNano() / int64(time.Millisecond))})
+		}
+		sort.Slice(t.Datapoints, func(i, j int) bool {
+			return t.Datapoints[i][1] < t.Datapoints[j][1]
+		})
+		targets = append(targets, t)
+	}
+	sort.Slice(targets, func(i, j int) bool {
+		return targets[i].Target < targets[j].Target
+	})
+	return json.NewEncoder(w).Encode(targets)
+}
+
+// PrometheusExporter writes grouped costs in the Prometheus text exposition
+// format as the aws_cost_unblended gauge labeled by the grouped fields
+type PrometheusExporter struct {
+	Fields []string
+}
+
+func (p PrometheusExporter) Export(w io.Writer, r Report, s, e time.Time) error {
+	return p.ExportResults(w, r.GroupByFields(p.Fields, s, e))
+}
+
+func (p PrometheusExporter) ExportResults(w io.Writer, results []GroupResult) error {
+	fields := supportedFields(p.Fields)
+	labels := make([]string, len(fields))
+	for i, field := range fields {
+		labels[i] = promLabelName(field)
+	}
+
+	if _, err := fmt.Fprint(w,
+		"# HELP aws_cost_unblended Unblended cost by group.\n",
+		"# TYPE aws_cost_unblended gauge\n",
+	); err != nil {
+		return err
+	}
+	for _, row := range results {
+		pairs := make([]string, len(labels))
+		for i, label := range labels {
+			pairs[i] = fmt.Sprintf("%s=%q", label, row.Values[i])
+		}
+		metric := "aws_cost_unblended"
+		if len(pairs) > 0 {
+			metric += "{" + strings.Join(pairs, ",") + "}"
+		}
+		if _, err := fmt.Fprintf(w, "%s %s\n", metric, strconv.FormatFloat(row.Cost, 'g', -1, 64)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// promLabelName converts a CUR column such as lineItem/ProductCode into a
+// valid Prometheus label name such as product_code
+func promLabelName(field string) string {
+	if i := strings.LastIndex(field, "/"); i >= 0 {
+		field = field[i+1:]
+	}
+	var b strings.Builder
+	for i, c := range field {
+		switch {
+		case unicode.IsUpper(c):
+			if i > 0 {
+				b.WriteByte('_')
+			}
+			b.WriteRune(unicode.ToLower(c))
+		case c < unicode.MaxASCII && (unicode.IsLetter(c) || unicode.IsDigit(c)):
+			b.WriteRune(c)
+		default:
+			b.WriteByte('_')
+		}
+	}
+	return b.String()
+}
+
+// XLSXExporter writes grouped costs as a single sheet Excel workbook
+type XLSXExporter struct {
+	Fields []string
+}
+
+func (x XLSXExporter) Export(w io.Writer, r Report, s, e time.Time) error {
+	return x.ExportResults(w, r.GroupByFields(x.Fields, s, e))
+}
+
+func (x XLSXExporter) ExportResults(w io.Writer, results []GroupResult) error {
+	var sheet strings.Builder
+	sheet.WriteString(xml.Header)
+	sheet.WriteString(`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+
+	writeRow := func(cells []string, cost *float64) {
+		sheet.WriteString("<row>")
+		for _, cell := range cells {
+			sheet.WriteString(`<c t="inlineStr"><is><t>`)
+			xml.EscapeText(&sheet, []byte(cell))
+			sheet.WriteString("</t></is></c>")
+		}
+		if cost != nil {
+			sheet.WriteString("<c><v>" + strconv.FormatFloat(*cost, 'f', -1, 64) + "</v></c>")
+		}
+		sheet.WriteString("</row>")
+	}
+
+	writeRow(append(supportedFields(x.Fields), "cost"), nil)
+	for _, row := range results {
+		cost := row.Cost
+		writeRow(row.Values, &cost)
+	}
+	sheet.WriteString("</sheetData></worksheet>")
+
+	files := []struct {
+		name string
+		body string
+	}{
+		{"[Content_Types].xml", xml.Header + `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+			`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+			`<Default Extension="xml" ContentType="application/xml"/>` +
+			`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+			`<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>` +
+			`</Types>`},
+		{"_rels/.rels", xml.Header + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+			`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+			`</Relationships>`},
+		{"xl/workbook.xml", xml.Header + `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" ` +
+			`xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+			`<sheets><sheet name="cost" sheetId="1" r:id="rId1"/></sheets></workbook>`},
+		{"xl/_rels/workbook.xml.rels", xml.Header + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+			`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>` +
+			`</Relationships>`},
+		{"xl/worksheets/sheet1.xml", sheet.String()},
+	}
+
+	zw := zip.NewWriter(w)
+	for _, f := range files {
+		fw, err := zw.Create(f.name)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(fw, f.body); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
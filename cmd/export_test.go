@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExportResults(t *testing.T) {
+	r := newTestReport(testItem(1, 0, "AmazonEC2", 2), testItem(2, 0, "AmazonS3", 1))
+	fields := []string{"lineItem/ProductCode"}
+
+	for _, format := range ExportFormats() {
+		if _, err := NewExporter(format, fields); err != nil {
+			t.Errorf("expected registered format %s to be supported, %v", format, err)
+		}
+	}
+
+	exporter, _ := NewExporter("csv", fields)
+	var full, top bytes.Buffer
+	if err := exporter.Export(&full, *r, testStart, testEnd); err != nil {
+		t.Fatal(err)
+	}
+	if err := exporter.(ResultsExporter).ExportResults(&top, r.GroupByTopN(fields, testStart, testEnd, 1)); err != nil {
+		t.Fatal(err)
+	}
+	if expected := "lineItem/ProductCode,cost\nAmazonEC2,2.00\nAmazonS3,1.00\n"; full.String() != expected {
+		t.Errorf("expected %q but got %q", expected, full.String())
+	}
+	if expected := "lineItem/ProductCode,cost\nAmazonEC2,2.00\n"; top.String() != expected {
+		t.Errorf("expected %q but got %q", expected, top.String())
+	}
+}
+
+func TestWriteLineItemsJSONL(t *testing.T) {
+	columns := append(append([]string(nil), testColumns...), "resourceTags/user_team")
+	data := testCSV(t, columns,
+		map[string]string{"lineItem/UnblendedCost": "1.25", "resourceTags/user_team": "web"},
+		map[string]string{"lineItem/ProductCode": "AmazonS3"},
+	)
+	items := parseTestCSV(t, data).FilterByTime(testStart, testEnd)
+
+	var buf bytes.Buffer
+	if err := WriteLineItemsJSONL(&buf, items); err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(buf.String(), "\n"); lines != len(items) {
+		t.Fatalf("expected %d lines but got %d", len(items), lines)
+	}
+	dec := json.NewDecoder(&buf)
+	for _, item := range items {
+		var l LineItem
+		if err := dec.Decode(&l); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(&l, item) {
+			t.Errorf("expected %+v to round trip but got %+v", item, l)
+		}
+	}
+}
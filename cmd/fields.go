@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// IsProdField is the derived field registered by RegisterProdAccounts
+const IsProdField = "env/isProd"
+
+// RegionField is the built in derived field holding the region of a line
+// item's availability zone
+const RegionField = "region"
+
+var (
+	derivedMu sync.RWMutex
+	// derivedGen is bumped whenever a field is registered so that cached
+	// query results computed with a replaced field are not reused
+	derivedGen    uint64
+	derivedFields = map[string]func(*LineItem) string{
+		// calendar month of the line item start in UTC, e.g. 2020-05
+		"time/Month": func(l *LineItem) string {
+			return l.Start.UTC().Format("2006-01")
+		},
+		// service and resource type from an ARN ResourceId, e.g. ec2:instance
+		"resource/Type": (*LineItem).ResourceType,
+		RegionField:     azRegion,
+	}
+)
+
+// RegisterField registers a derived field computed from each line item that
+// can be referenced by name wherever a CUR column is accepted, e.g. GroupBy.
+// Registering an existing name replaces it.
+func RegisterField(name string, fn func(*LineItem) string) {
+	derivedMu.Lock()
+	derivedFields[name] = fn
+	derivedGen++
+	derivedMu.Unlock()
+}
+
+func init() {
+	RegisterTimeFields(time.UTC)
+}
+
+// RegisterTimeFields registers the time/HourOfDay (00-23) and time/DayOfWeek
+// (Sunday-Saturday) fields computed from each line item's start in loc. They
+// are registered in UTC by default.
+func RegisterTimeFields(loc *time.Location) {
+	RegisterField("time/HourOfDay", func(l *LineItem) string {
+		return fmt.Sprintf("%02d", l.Start.In(loc).Hour())
+	})
+	RegisterField("time/DayOfWeek", func(l *LineItem) string {
+		return l.Start.In(loc).Weekday().String()
+	})
+}
+
+// azRegion strips the zone letter from the line item's availability zone, e.g.
+// us-east-1a becomes us-east-1, falling back to product/region for line items
+// without a zone
+func azRegion(l *LineItem) string {
+	az := l.AvailabilityZone
+	if n := len(az); n >= 2 && az[n-1] >= 'a' && az[n-1] <= 'z' && az[n-2] >= '0' && az[n-2] <= '9' {
+		return az[:n-1]
+	}
+	return l.Region
+}
+
+// RegisterFieldsFile registers the derived fields defined in a text file that
+// map the values of another field to labels. Each line names the derived
+// field, the field it is computed from and value=label pairs, where a value of
+// * labels every other value. Values without a label are kept as is. Blank
+// lines and lines starting with # are ignored.
+//
+//	product/Family lineItem/ProductCode AmazonEC2=EC2 AmazonRDS=RDS *=Other
+func RegisterFieldsFile(path string) error {
+	fh, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+
+	sc := bufio.NewScanner(fh)
+	var lineNum int
+	for sc.Scan() {
+		lineNum++
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.Fields(line)
+		if len(parts) < 3 {
+			return fmt.Errorf("Invalid derived field on line %d, %s", lineNum, line)
+		}
+		name, source := parts[0], parts[1]
+		if !isSupportedField(source) {
+			return fmt.Errorf("Unsupported field to derive %s from on line %d, %s", name, lineNum, source)
+		}
+		labels := make(map[string]string, len(parts)-2)
+		for _, pair := range parts[2:] {
+			i := strings.Index(pair, "=")
+			if i < 0 {
+				return fmt.Errorf("Invalid value mapping on line %d, %s", lineNum, pair)
+			}
+			labels[pair[:i]] = pair[i+1:]
+		}
+		RegisterField(name, mappedField(source, labels))
+	}
+	return sc.Err()
+}
+
+// mappedField returns a derived field labeling the values of source
+func mappedField(source string, labels map[string]string) func(*LineItem) string {
+	other, hasOther := labels["*"]
+	return func(l *LineItem) string {
+		val, _ := l.FieldValue(source)
+		if label, exists := labels[val]; exists {
+			return label
+		}
+		if hasOther {
+			return other
+		}
+		return val
+	}
+}
+
+// isSupportedField returns whether field can be grouped or filtered on
+func isSupportedField(field string) bool {
+	_, ok := (&LineItem{Bill: &Bill{}}).FieldValue(field)
+	return ok
+}
+
+// ValidateQuery checks that every field to group by and every filter field is
+// supported, including resource tags and registered derived fields, without
+// scanning any line items. The returned error lists all unsupported fields.
+func (r Report) ValidateQuery(fields []string, filters map[string]string) error {
+	var unsupported []string
+	for _, field := range fields {
+		if !isSupportedField(field) {
+			unsupported = append(unsupported, field)
+		}
+	}
+	filterFields := make([]string, 0, len(filters))
+	for field := range filters {
+		filterFields = append(filterFields, field)
+	}
+	sort.Strings(filterFields)
+	for _, field := range filterFields {
+		if !isSupportedField(field) {
+			unsupported = append(unsupported, field)
+		}
+	}
+	if len(unsupported) > 0 {
+		return fmt.Errorf("Unsupported query fields, %s", strings.Join(unsupported, ", "))
+	}
+	return nil
+}
+
+// derivedField returns the derived field registered under name
+func derivedField(name string) (func(*LineItem) string, bool) {
+	derivedMu.RLock()
+	fn, exists := derivedFields[name]
+	derivedMu.RUnlock()
+	return fn, exists
+}
+
+// derivedGeneration returns the number of fields registered so far
+func derivedGeneration() uint64 {
+	derivedMu.RLock()
+	defer derivedMu.RUnlock()
+	return derivedGen
+}
+
+// RegisterProdAccounts registers the env/isProd field which is "true" for line
+// items whose UsageAccountId is in accounts and "false" otherwise. Grouping by
+// it splits spend into production and non-production in one query.
+func RegisterProdAccounts(accounts []string) {
+	prod := make(map[string]struct{}, len(accounts))
+	for _, account := range accounts {
+		prod[account] = struct{}{}
+	}
+	RegisterField(IsProdField, func(l *LineItem) string {
+		if _, exists := prod[l.UsageAccountID]; exists {
+			return "true"
+		}
+		return "false"
+	})
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bufio"
+	"encoding/gob"
+	"fmt"
+	"os"
+)
+
+// cacheVersion is written as the first byte of a cache file and bumped
+// whenever the encoded layout changes so that stale caches are rejected
+const cacheVersion byte = 3
+
+// cachedReport is the gob encoded body of a cache file. Bills are shared by
+// many line items, so they are stored once and referenced by index.
+type cachedReport struct {
+	Columns   []string
+	Bills     []Bill
+	LineItems []LineItem // in start order with Bill left nil
+	BillIdx   []int      // index into Bills for each line item, -1 if none
+}
+
+// Save writes the line items, bills and columns of the report to a cache
+// file at path that LoadReport reads back much faster than reparsing the CUR
+func (r Report) Save(path string) error {
+	c := cachedReport{Columns: r.Columns()}
+	billIdx := make(map[*Bill]int)
+	for _, t := range r.TimePts {
+		for _, item := range r.LineItems[t] {
+			idx := -1
+			if item.Bill != nil {
+				var exists bool
+				if idx, exists = billIdx[item.Bill]; !exists {
+					idx = len(c.Bills)
+					billIdx[item.Bill] = idx
+					c.Bills = append(c.Bills, *item.Bill)
+				}
+			}
+			l := *item
+			l.Bill = nil
+			c.LineItems = append(c.LineItems, l)
+			c.BillIdx = append(c.BillIdx, idx)
+		}
+	}
+
+	fh, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(fh)
+	if err := w.WriteByte(cacheVersion); err != nil {
+		fh.Close()
+		return err
+	}
+	if err := gob.NewEncoder(w).Encode(c); err != nil {
+		fh.Close()
+		return fmt.Errorf("Could not encode report cache, %v", err)
+	}
+	if err := w.Flush(); err != nil {
+		fh.Close()
+		return err
+	}
+	return fh.Close()
+}
+
+// LoadReport reads a report written by Save. An error is returned if the cache
+// was written in a different format version.
+func LoadReport(path string, opts ...Option) (*Report, error) {
+	fh, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+
+	rd := bufio.NewReader(fh)
+	version, err := rd.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	if version != cacheVersion {
+		return nil, fmt.Errorf("Unsupported report cache version, %d", version)
+	}
+	var c cachedReport
+	if err := gob.NewDecoder(rd).Decode(&c); err != nil {
+		return nil, fmt.Errorf("Could not decode report cache, %v", err)
+	}
+
+	r := newOptions(opts).newReport()
+	if c.Columns != nil {
+		r.addColumns(c.Columns)
+	}
+	for i := range c.LineItems {
+		l := &c.LineItems[i]
+		if idx := c.BillIdx[i]; idx >= 0 {
+			l.Bill = &c.Bills[idx]
+		}
+		r.AddLineItem(l)
+	}
+	return r, nil
+}
+
+// NewReportCached loads the report from the cache file at cachePath if it is
+// newer than the CUR csv at filename, and otherwise parses filename like
+// NewReport and saves the result to cachePath for the next run. A report with
+// ParseErrors is returned but not cached so that the errors are not hidden, and
+// a cache that cannot be written is logged rather than failing the load.
+func NewReportCached(filename, cachePath string, opts ...Option) (*Report, error) {
+	src, err := os.Stat(filename)
+	if err != nil {
+		return nil, err
+	}
+	if cache, err := os.Stat(cachePath); err == nil && cache.ModTime().After(src.ModTime()) {
+		r, err := LoadReport(cachePath, opts...)
+		if err == nil {
+			return r, nil
+		}
+		newOptions(opts).newReport().logf("Ignoring report cache, %v\n", err)
+	}
+
+	r, err := NewReport(filename, opts...)
+	if err != nil {
+		return r, err
+	}
+	if err := r.Save(cachePath); err != nil {
+		r.logf("Could not save report cache, %v\n", err)
+	}
+	return r, nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSaveLoadReport(t *testing.T) {
+	columns := append(append([]string(nil), testColumns...), "resourceTags/user_team")
+	data := testCSV(t, columns,
+		map[string]string{"resourceTags/user_team": "web"},
+		map[string]string{"identity/TimeInterval": "2020-05-01T01:00:00Z/2020-05-01T02:00:00Z", "lineItem/UnblendedCost": "2"},
+	)
+	r := parseTestCSV(t, data)
+
+	path := filepath.Join(t.TempDir(), "report.cache")
+	if err := r.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadReport(path, WithLogger(discardLogger))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, got := r.FilterByTime(testStart, testEnd), loaded.FilterByTime(testStart, testEnd)
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v but got %v", expected, got)
+	}
+	if got[0].Bill != got[1].Bill {
+		t.Error("expected the loaded line items to share their bill")
+	}
+	if !reflect.DeepEqual(loaded.Columns(), r.Columns()) {
+		t.Errorf("expected columns %v but got %v", r.Columns(), loaded.Columns())
+	}
+
+	// a cache written by another version is rejected
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw[0] = cacheVersion + 1
+	if err := ioutil.WriteFile(path, raw, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadReport(path); err == nil {
+		t.Error("expected a cache of another version to be rejected")
+	}
+}
+
+func TestNewReportCachedSaveError(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "cur.csv")
+	if err := ioutil.WriteFile(filename, []byte(testCSV(t, nil, map[string]string{})), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// the cache path is under a missing directory so it cannot be written
+	cachePath := filepath.Join(dir, "missing", "report.cache")
+
+	logger := &captureLogger{}
+	r, err := NewReportCached(filename, cachePath, WithLogger(logger))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if items := r.FilterByTime(testStart, testEnd); len(items) != 1 {
+		t.Errorf("expected 1 line item but got %d", len(items))
+	}
+	if len(logger.msgs) != 1 {
+		t.Errorf("expected the cache error to be logged but got %v", logger.msgs)
+	}
+	if _, err := os.Stat(cachePath); err == nil {
+		t.Error("expected no cache to be written")
+	}
+}
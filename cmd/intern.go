@@ -0,0 +1,77 @@
+package main
+
+import "strings"
+
+// interner dedupes the low cardinality values of parsed line items, such as
+// ProductCode and Operation or their Bill, so that line items with equal values
+// share one allocation. It is not safe for concurrent use.
+type interner struct {
+	strings map[string]string
+	bills   map[Bill]*Bill
+}
+
+func newInterner() *interner {
+	return &interner{strings: make(map[string]string), bills: make(map[Bill]*Bill)}
+}
+
+func (in *interner) intern(s string) string {
+	if v, exists := in.strings[s]; exists {
+		return v
+	}
+	s = detach(s)
+	in.strings[s] = s
+	return s
+}
+
+// detach copies s into its own allocation. The fields of a csv record are
+// substrings of the whole row, so keeping any one of them would otherwise keep
+// the entire row alive.
+func detach(s string) string {
+	if s == "" {
+		return ""
+	}
+	var b strings.Builder
+	b.Grow(len(s))
+	b.WriteString(s)
+	return b.String()
+}
+
+// intern replaces the repeated string fields of the line item and its bill
+// with shared copies from in and detaches its unique ones from the csv row
+func (l *LineItem) intern(in *interner) {
+	for _, s := range []*string{
+		&l.AvailabilityZone,
+		&l.CurrencyCode,
+		&l.LegalEntity,
+		&l.LineItemDescription,
+		&l.LineItemType,
+		&l.Operation,
+		&l.ProductCode,
+		&l.TaxType,
+		&l.UsageAccountID,
+		&l.UsageType,
+		&l.Region,
+	} {
+		*s = in.intern(*s)
+	}
+	l.LineItemID = detach(l.LineItemID)
+	l.ResourceID = detach(l.ResourceID)
+	l.ReservationARN = in.intern(l.ReservationARN)
+
+	if l.Bill != nil {
+		if b, exists := in.bills[*l.Bill]; exists {
+			l.Bill = b
+		} else {
+			l.Bill.BillingEntity = in.intern(l.Bill.BillingEntity)
+			l.Bill.BillType = in.intern(l.Bill.BillType)
+			l.Bill.InvoiceID = in.intern(l.Bill.InvoiceID)
+			l.Bill.PayerAccountID = in.intern(l.Bill.PayerAccountID)
+			in.bills[*l.Bill] = l.Bill
+		}
+	}
+	// tag keys are the column names of the header rather than substrings of
+	// the row
+	for key, val := range l.Tags {
+		l.Tags[key] = in.intern(val)
+	}
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// NewReportFromZip parses every csv entry of a zip archive into one report.
+// Entries are processed in sorted name order and may themselves be gzipped.
+// Malformed rows of every entry are collected into a single ParseErrors naming
+// the entry of each row.
+func NewReportFromZip(path string, opts ...Option) (*Report, error) {
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	files := make([]*zip.File, 0, len(zr.File))
+	for _, f := range zr.File {
+		name := strings.ToLower(f.Name)
+		if strings.HasSuffix(name, ".csv") || strings.HasSuffix(name, ".csv.gz") {
+			files = append(files, f)
+		}
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Name < files[j].Name
+	})
+
+	o := newOptions(opts)
+	r := o.newReport()
+	var errs ParseErrors
+	for _, f := range files {
+		err := r.parseZipFile(f, o)
+		if perrs, ok := err.(ParseErrors); ok {
+			for _, perr := range perrs {
+				perr.File = f.Name
+				errs = append(errs, perr)
+			}
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	if len(errs) > 0 {
+		return r, errs
+	}
+	return r, nil
+}
+
+// parseZipFile adds the line items of a single zip entry to the report
+func (r *Report) parseZipFile(f *zip.File, o options) error {
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	var rd io.Reader = rc
+	if strings.HasSuffix(strings.ToLower(f.Name), ".gz") {
+		gz, err := newGzipReader(rc)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		rd = gz
+	}
+	return r.parseCSV(rd, o)
+}
+
+// gzipMagic are the leading bytes of every gzip stream
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// newGzipReader returns a reader of every member of a gzip stream. Some CUR
+// tooling concatenates several gzip members into one file, so multistream
+// mode is set explicitly rather than relying on the default.
+func newGzipReader(rd io.Reader) (*gzip.Reader, error) {
+	gz, err := gzip.NewReader(rd)
+	if err != nil {
+		return nil, err
+	}
+	gz.Multistream(true)
+	return gz, nil
+}
+
+// maybeGzip returns a reader of the uncompressed contents of rd, detecting gzip
+// from its leading bytes. The returned close func releases the gzip reader.
+func maybeGzip(rd io.Reader) (io.Reader, func() error, error) {
+	br := bufio.NewReader(rd)
+	magic, err := br.Peek(len(gzipMagic))
+	if err != nil && err != io.EOF {
+		return nil, nil, err
+	}
+	if !bytes.Equal(magic, gzipMagic) {
+		return br, func() error { return nil }, nil
+	}
+	gz, err := newGzipReader(br)
+	if err != nil {
+		return nil, nil, err
+	}
+	return gz, gz.Close, nil
+}
+
+// NewReportFromURL downloads and parses a CUR csv over HTTP. The body may be
+// plain or gzipped. ctx bounds the whole request including reading the body.
+func NewReportFromURL(ctx context.Context, url string, opts ...Option) (*Report, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Unexpected status fetching %s, %s", url, resp.Status)
+	}
+
+	return NewReportFromReaderContext(ctx, resp.Body, opts...)
+}
+
+// curManifest is the subset of a CUR *-Manifest.json needed to locate its
+// data files
+type curManifest struct {
+	AssemblyID string   `json:"assemblyId"`
+	ReportKeys []string `json:"reportKeys"`
+}
+
+// NewReportFromManifest parses every data file listed in the reportKeys of a
+// CUR manifest into one report. Line items repeated across parts are only
+// added once. Malformed rows of every part are collected into a single
+// ParseErrors naming the report key of each row.
+func NewReportFromManifest(manifestPath string, opts ...Option) (*Report, error) {
+	fh, err := os.Open(manifestPath)
+	if err != nil {
+		return nil, err
+	}
+	var m curManifest
+	err = json.NewDecoder(fh).Decode(&m)
+	fh.Close()
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse manifest, %v", err)
+	}
+
+	dir := filepath.Dir(manifestPath)
+	o := newOptions(opts)
+	r := o.newReport()
+	var errs ParseErrors
+	for _, key := range m.ReportKeys {
+		path, err := resolveReportKey(dir, key)
+		if err != nil {
+			return nil, err
+		}
+		err = r.parseFile(path, o)
+		if perrs, ok := err.(ParseErrors); ok {
+			for _, perr := range perrs {
+				perr.File = key
+				errs = append(errs, perr)
+			}
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	if len(errs) > 0 {
+		return r, errs
+	}
+	return r, nil
+}
+
+// resolveReportKey finds the local copy of the S3 key of a manifest data file.
+// Keys hold the full report prefix, so the longest trailing part of the key
+// that exists under dir is used, down to the bare file name.
+func resolveReportKey(dir, key string) (string, error) {
+	parts := strings.Split(strings.Trim(key, "/"), "/")
+	for i := range parts {
+		path := filepath.Join(dir, filepath.Join(parts[i:]...))
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("Could not find report key, %s, under %s", key, dir)
+}
+
+// parseFile adds the line items of a CUR csv file, which may be gzipped, to
+// the report
+func (r *Report) parseFile(path string, o options) error {
+	fh, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+	return r.parseReader(fh, o)
+}
+
+// parseReader adds the line items of a CUR csv, which may be gzipped, to the
+// report
+func (r *Report) parseReader(rd io.Reader, o options) error {
+	csvRd, closeFn, err := maybeGzip(rd)
+	if err != nil {
+		return err
+	}
+	defer closeFn()
+	return r.parseCSV(csvRd, o)
+}
+
+// NewReportFromDir parses every *.csv.gz file in dir into one report. Up to
+// Workers files, defaulting to GOMAXPROCS, are parsed concurrently with the
+// rows of each parsed serially, and each file is merged into the report in
+// sorted name order as soon as it and the files before it are parsed, with
+// duplicate line items resolved as in Merge. A file that cannot be parsed at
+// all is skipped like a malformed row, so the report of the remaining files is
+// returned with ParseErrors naming each failed file and row unless the Strict
+// option is set.
+func NewReportFromDir(dir string, opts ...Option) (*Report, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.csv.gz"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+
+	o := newOptions(opts)
+	// files are the unit of concurrency so that at most Workers goroutines
+	// parse at once
+	fileOpts := o
+	fileOpts.workers = 1
+
+	r := o.newReport()
+	var perrs ParseErrors
+	var failed error
+	// merged[i] is closed once the i-th file is merged, which the next file
+	// waits on before merging. A file keeps its worker slot until merged, so
+	// at most Workers parsed reports are held at once.
+	merged := make([]chan struct{}, len(paths))
+	for i := range merged {
+		merged[i] = make(chan struct{})
+	}
+	sem := make(chan struct{}, o.workers)
+	var wg sync.WaitGroup
+	for i, path := range paths {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, path string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			defer close(merged[i])
+			report := o.newReport()
+			err := report.parseFile(path, fileOpts)
+			if i > 0 {
+				<-merged[i-1]
+			}
+			if failed != nil {
+				return
+			}
+
+			name := filepath.Base(path)
+			switch err := err.(type) {
+			case nil:
+			case ParseErrors:
+				for _, perr := range err {
+					perr.File = name
+					perrs = append(perrs, perr)
+				}
+			default:
+				if o.strict {
+					failed = fmt.Errorf("Could not parse %s, %v", name, err)
+					return
+				}
+				perrs = append(perrs, ParseError{File: name, Err: err})
+				return
+			}
+			r.Merge(report)
+		}(i, path)
+	}
+	wg.Wait()
+
+	if failed != nil {
+		return nil, failed
+	}
+	if len(perrs) > 0 {
+		return r, perrs
+	}
+	return r, nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"archive/zip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// badRowCSV returns a csv of one good and one malformed row
+func badRowCSV(t *testing.T) string {
+	return testCSV(t, nil,
+		map[string]string{"identity/LineItemId": "a"},
+		map[string]string{"identity/LineItemId": "b", "lineItem/UnblendedCost": "x"},
+	)
+}
+
+func TestNewReportFromZipErrorFile(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "cur.zip")
+	fh, err := os.Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(fh)
+	w, err := zw.Create("cur/part-1.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte(badRowCSV(t)))
+	zw.Close()
+	fh.Close()
+
+	_, err = NewReportFromZip(filename, WithLogger(discardLogger))
+	perrs, ok := err.(ParseErrors)
+	if !ok || len(perrs) != 1 {
+		t.Fatalf("expected the malformed row to be reported but got %v", err)
+	}
+	if perrs[0].File != "cur/part-1.csv" {
+		t.Errorf("expected the error to name cur/part-1.csv but got %q", perrs[0].File)
+	}
+}
+
+func TestNewReportFromManifestErrorFile(t *testing.T) {
+	dir := t.TempDir()
+	manifest := filepath.Join(dir, "r-Manifest.json")
+	if err := ioutil.WriteFile(manifest, []byte(`{"reportKeys": ["cur/r/part-1.csv"]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "part-1.csv"), []byte(badRowCSV(t)), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := NewReportFromManifest(manifest, WithLogger(discardLogger))
+	perrs, ok := err.(ParseErrors)
+	if !ok || len(perrs) != 1 {
+		t.Fatalf("expected the malformed row to be reported but got %v", err)
+	}
+	if perrs[0].File != "cur/r/part-1.csv" {
+		t.Errorf("expected the error to name cur/r/part-1.csv but got %q", perrs[0].File)
+	}
+}
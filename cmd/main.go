@@ -1,12 +1,16 @@
 package main
 
 import (
-	"bufio"
-	"compress/gzip"
+	"context"
+	"encoding/csv"
 	"encoding/json"
+	"flag"
 	"fmt"
+	"io"
 	"log"
+	"math/big"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -19,132 +23,538 @@ var (
 	timeLayout = "2006-01-02T15:04:05Z"
 )
 
+// byteOrderMark is the UTF-8 byte order mark some tools prepend to csv files
+const byteOrderMark = "\ufeff"
+
+// tagPrefix prefixes the CUR columns of cost allocation and resource tags
+const tagPrefix = "resourceTags/"
+
+// Logger is the minimal logging interface used by a Report, satisfied by
+// *log.Logger and most structured loggers
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
 type Report struct {
 	LineItems map[time.Time][]*LineItem // map of start timestamps to a slice of LineItemIDs
 	TimePts   []time.Time               // sorted order of start timestamps with identity
-}
 
-func NewReport(filename string) (*Report, error) {
-	var err error
+	// HighPrecision accumulates grouped totals with math/big instead of float64
+	// so that summing many small costs does not drift, at the cost of speed
+	HighPrecision bool
+
+	// ExcludeTax drops Tax line items from aggregated totals so that they are
+	// reported pre-tax. Taxes are included by default.
+	ExcludeTax bool
+
+	// PositiveOnly drops line items with a zero or negative UnblendedCost, such
+	// as credits, refunds and discounts, from aggregated totals. By default
+	// they are netted against spend as in the AWS console.
+	PositiveOnly bool
+
+	// NormalizeAZ replaces empty and "Not Applicable" availability zones with
+	// "regional" or "global" when grouping by lineItem/AvailabilityZone
+	NormalizeAZ bool
+
+	// InclusiveEnd closes query windows at their end so that line items
+	// starting exactly at e are included. Windows are half-open by default.
+	InclusiveEnd bool
 
-	r := &Report{LineItems: make(map[time.Time][]*LineItem)}
+	// Logger receives notices such as duplicate line items and unsupported
+	// fields. The package logger writing to stdout is used if it is nil.
+	Logger Logger
+
+	resourceIdx map[string][]*LineItem // optional map of ResourceId to line items sorted by start
+	columns     map[string]struct{}    // set of columns present in the parsed files
+	cache       *queryCache            // optional memoized query results
+	seen        map[uint64]*LineItem   // line items in the report keyed by UID
+}
+
+// NewReport parses a CUR csv file, which may be gzipped. Malformed rows are
+// skipped and returned as ParseErrors together with the rest of the report
+// unless the Strict option is set.
+func NewReport(filename string, opts ...Option) (r *Report, err error) {
 	fh, err := os.Open(filename)
 	if err != nil {
 		return nil, err
 	}
+	defer closeOnReturn(fh.Close, &r, &err)
+
+	return NewReportFromReaderContext(context.Background(), fh, opts...)
+}
 
-	gz, err := gzip.NewReader(fh)
+// NewReportFromReader parses a CUR csv from rd, which may be gzipped. Like
+// NewReport it returns the parsed report with any ParseErrors.
+func NewReportFromReader(rd io.Reader, opts ...Option) (*Report, error) {
+	return NewReportFromReaderContext(context.Background(), rd, opts...)
+}
+
+// NewReportFromReaderContext parses like NewReportFromReader but stops
+// shortly after ctx is done, discarding the partial report and returning
+// ctx.Err()
+func NewReportFromReaderContext(ctx context.Context, rd io.Reader, opts ...Option) (r *Report, err error) {
+	o := newOptions(opts)
+	o.ctx = ctx
+
+	csvRd, closeFn, err := maybeGzip(rd)
 	if err != nil {
 		return nil, err
 	}
+	defer closeOnReturn(closeFn, &r, &err)
+
+	r = o.newReport()
+	if err := r.parseCSV(csvRd, o); err != nil {
+		if _, ok := err.(ParseErrors); !ok {
+			return nil, err
+		}
+		return r, err
+	}
+	return r, nil
+}
 
-	scanner := bufio.NewScanner(gz)
+// closeOnReturn is deferred by the report constructors so that the file or
+// decompressor behind a report is closed on every return path, including a
+// scan aborted part way through. A failure to close discards the report
+// unless parsing already failed with a more specific error.
+func closeOnReturn(closeFn func() error, r **Report, err *error) {
+	cerr := closeFn()
+	if cerr == nil {
+		return
+	}
+	if _, ok := (*err).(ParseErrors); *err == nil || ok {
+		*r, *err = nil, cerr
+	}
+}
 
-	scanner.Scan()
-	headers := strings.Split(scanner.Text(), ",")
+// parseCSV adds the line items of an uncompressed CUR csv to the report
+func (r *Report) parseCSV(rd io.Reader, o options) error {
+	return scanCSV(rd, o, r.addColumns, func(l *LineItem) bool {
+		r.AddLineItem(l)
+		return true
+	})
+}
+
+// scanCSV parses an uncompressed CUR csv, passing its header to onHeader and
+// each line item to fn until fn returns false. Rows are parsed concurrently
+// by o.workers goroutines but passed to fn in file order from the calling
+// goroutine. Malformed rows are skipped and returned together as ParseErrors
+// unless o.strict is set, in which case the first one aborts the scan.
+func scanCSV(rd io.Reader, o options, onHeader func(headers []string), fn func(*LineItem) bool) error {
+	cr := csv.NewReader(rd)
+
+	headers, err := cr.Read()
+	if err != nil {
+		return err
+	}
+	headers = normalizeHeaders(headers)
 	headerIdx := make(map[string]int)
+	var tagCols []string
 	for i, header := range headers {
 		headerIdx[header] = i
+		if strings.HasPrefix(header, tagPrefix) {
+			tagCols = append(tagCols, header)
+		}
+	}
+	if onHeader != nil {
+		onHeader(headers)
 	}
 
-	for scanner.Scan() {
-		parts := strings.Split(scanner.Text(), ",")
-		l, err := NewLineItem(
-			parts[headerIdx["identity/LineItemId"]],
-			parts[headerIdx["identity/TimeInterval"]],
-			parts[headerIdx["lineItem/AvailabilityZone"]],
-			parts[headerIdx["lineItem/BlendedCost"]],
-			parts[headerIdx["lineItem/BlendedCost"]],
-			parts[headerIdx["lineItem/CurrencyCode"]],
-			parts[headerIdx["lineItem/LegalEntity"]],
-			parts[headerIdx["lineItem/LineItemDescription"]],
-			parts[headerIdx["lineItem/LineItemType"]],
-			parts[headerIdx["lineItem/NormalizationFactor"]],
-			parts[headerIdx["lineItem/Operation"]],
-			parts[headerIdx["lineItem/ProductCode"]],
-			parts[headerIdx["lineItem/ResourceId"]],
-			parts[headerIdx["lineItem/TaxType"]],
-			parts[headerIdx["lineItem/UnblendedCost"]],
-			parts[headerIdx["lineItem/UnblendedRate"]],
-			parts[headerIdx["lineItem/UsageAccountId"]],
-			parts[headerIdx["lineItem/UsageAmount"]],
-			parts[headerIdx["lineItem/UsageStartDate"]],
-			parts[headerIdx["lineItem/UsageEndDate"]],
-			parts[headerIdx["lineItem/UsageType"]],
-		)
-		if err != nil {
-			return nil, err
+	// some reshaped exports hold every cost variant of a line item in a single
+	// cost column across several rows, one per cost_type
+	var costTypeItems map[uint64]*LineItem
+	_, hasCost := headerIdx["cost"]
+	_, hasCostType := headerIdx["cost_type"]
+	_, hasUnblended := headerIdx["lineItem/UnblendedCost"]
+	if hasCost && hasCostType && !hasUnblended {
+		costTypeItems = make(map[uint64]*LineItem)
+	}
+	if err := checkRequiredColumns(headerIdx, costTypeItems != nil); err != nil {
+		return err
+	}
+	if costTypeItems != nil && o.stream {
+		// a line item is only complete once every one of its cost_type rows has
+		// been read, which may be anywhere in the file
+		return fmt.Errorf("Cannot stream exports with a cost_type column, load them with NewReport")
+	}
+
+	stop := make(chan struct{})
+	batches := parseBatches(cr, o.workers, stop, func(record []string) (*LineItem, error) {
+		return lineItemFromRecord(record, headerIdx, tagCols, costTypeItems != nil, o.timeLayout)
+	})
+	defer func() {
+		close(stop)
+		for range batches {
 		}
-		l.Bill, err = NewBill(
-			parts[headerIdx["bill/Entity"]],
-			parts[headerIdx["bill/BillType"]],
-			parts[headerIdx["bill/InvoiceId"]],
-			parts[headerIdx["bill/PayerAccountId"]],
-			parts[headerIdx["bill/BillingPeriodStartDate"]],
-			parts[headerIdx["bill/BillingPeriodEndDate"]],
-		)
-		if err != nil {
-			return nil, err
+	}()
+
+	var errs ParseErrors
+	var row int
+	var pending []*LineItem // line items of a cost_type export in file order
+	// values are only interned when building a report, streamed line items are
+	// dropped once passed on so the interner would only grow without bound
+	in := newInterner()
+scan:
+	for b := range batches {
+		if err := o.ctx.Err(); err != nil {
+			return err
+		}
+		<-b.parsed
+		for i, record := range b.records {
+			if o.limit > 0 && row >= o.limit {
+				break scan
+			}
+			row++
+			o.progress.add()
+			l, err := b.items[i], b.errs[i]
+			if err == nil && costTypeItems != nil {
+				if existing, exists := costTypeItems[l.UID]; exists {
+					err = existing.setCost(record[headerIdx["cost_type"]], record[headerIdx["cost"]])
+					if err == nil {
+						continue
+					}
+				} else if err = l.setCost(record[headerIdx["cost_type"]], record[headerIdx["cost"]]); err == nil {
+					costTypeItems[l.UID] = l
+					pending = append(pending, l)
+					continue
+				}
+			}
+			if err == nil {
+				if !o.stream {
+					l.intern(in)
+				}
+				if !fn(l) {
+					break scan
+				}
+				continue
+			}
+
+			perr := ParseError{Row: row, Err: err}
+			if o.strict {
+				return perr
+			}
+			errs = append(errs, perr)
+		}
+		if b.readErr != nil {
+			return b.readErr
 		}
-		r.AddLineItem(l)
 	}
 
-	err = fh.Close()
-	if gzerr := gz.Close(); err == nil {
-		err = gzerr
+	// line items of a cost_type export are only passed on once all of their
+	// costs have been read
+	for _, l := range pending {
+		l.intern(in)
+		if !fn(l) {
+			break
+		}
 	}
 
-	return r, err
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
 }
 
-func (r *Report) AddLineItem(l *LineItem) {
-	lids, exists := r.LineItems[l.Start]
-	if exists {
-		for _, lid := range lids {
-			if lid.UID == l.UID {
-				logger.Printf("LineItemID, %s, already exists in Identity\n", l.UID)
-				return
+// lineItemFromRecord builds a line item from a single CUR csv record. Costs
+// are left at zero for exports that hold them in a separate cost_type row.
+func lineItemFromRecord(record []string, headerIdx map[string]int, tagCols []string, costTypeRows bool, layout string) (*LineItem, error) {
+	blendedCost, unblendedCost := "0", "0"
+	if !costTypeRows {
+		blendedCost = record[headerIdx["lineItem/BlendedCost"]]
+		unblendedCost = record[headerIdx["lineItem/UnblendedCost"]]
+	}
+	l, err := newLineItem(
+		layout,
+		record[headerIdx["identity/LineItemId"]],
+		record[headerIdx["identity/TimeInterval"]],
+		optionalColumn(record, headerIdx, "lineItem/AvailabilityZone"),
+		blendedCost,
+		optionalColumn(record, headerIdx, "lineItem/BlendedRate"),
+		optionalColumn(record, headerIdx, "lineItem/CurrencyCode"),
+		optionalColumn(record, headerIdx, "lineItem/LegalEntity"),
+		optionalColumn(record, headerIdx, "lineItem/LineItemDescription"),
+		record[headerIdx["lineItem/LineItemType"]],
+		optionalColumn(record, headerIdx, "lineItem/NormalizationFactor"),
+		record[headerIdx["lineItem/Operation"]],
+		record[headerIdx["lineItem/ProductCode"]],
+		optionalColumn(record, headerIdx, "lineItem/ResourceId"),
+		optionalColumn(record, headerIdx, "lineItem/TaxType"),
+		unblendedCost,
+		optionalColumn(record, headerIdx, "lineItem/UnblendedRate"),
+		record[headerIdx["lineItem/UsageAccountId"]],
+		optionalColumn(record, headerIdx, "lineItem/UsageAmount"),
+		optionalColumn(record, headerIdx, "lineItem/UsageStartDate"),
+		optionalColumn(record, headerIdx, "lineItem/UsageEndDate"),
+		record[headerIdx["lineItem/UsageType"]],
+	)
+	if err != nil {
+		return nil, err
+	}
+	l.Bill, err = newBill(
+		layout,
+		optionalColumn(record, headerIdx, "bill/Entity"),
+		optionalColumn(record, headerIdx, "bill/BillType"),
+		optionalColumn(record, headerIdx, "bill/InvoiceId"),
+		record[headerIdx["bill/PayerAccountId"]],
+		record[headerIdx["bill/BillingPeriodStartDate"]],
+		record[headerIdx["bill/BillingPeriodEndDate"]],
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, col := range tagCols {
+		val := record[headerIdx[col]]
+		if val == "" {
+			continue
+		}
+		if l.Tags == nil {
+			l.Tags = make(map[string]string)
+		}
+		l.Tags[col] = val
+	}
+	if tags := optionalColumn(record, headerIdx, cur2TagsColumn); tags != "" {
+		cur2Tags := make(map[string]string)
+		if err := json.Unmarshal([]byte(tags), &cur2Tags); err != nil {
+			return nil, fmt.Errorf("Could not parse resource tags, %v", err)
+		}
+		for key, val := range cur2Tags {
+			if val == "" {
+				continue
 			}
+			if l.Tags == nil {
+				l.Tags = make(map[string]string)
+			}
+			l.Tags[tagPrefix+key] = val
 		}
+	}
+
+	l.PublicOnDemandCost, err = optionalFloat(record, headerIdx, "pricing/publicOnDemandCost")
+	if err != nil {
+		return nil, err
+	}
+	l.SavingsPlanEffectiveCost, err = optionalFloat(record, headerIdx, "savingsPlan/SavingsPlanEffectiveCost")
+	if err != nil {
+		return nil, err
+	}
+	l.AmortizedUpfrontFeeForBillingPeriod, err = optionalFloat(record, headerIdx, "reservation/AmortizedUpfrontFeeForBillingPeriod")
+	if err != nil {
+		return nil, err
+	}
+	l.AmortizedUpfrontCostForUsage, err = optionalFloat(record, headerIdx, "reservation/AmortizedUpfrontCostForUsage")
+	if err != nil {
+		return nil, err
+	}
+	l.RecurringFeeForUsage, err = optionalFloat(record, headerIdx, "reservation/RecurringFeeForUsage")
+	if err != nil {
+		return nil, err
+	}
+	l.ReservationEffectiveCost, err = optionalFloat(record, headerIdx, "reservation/EffectiveCost")
+	if err != nil {
+		return nil, err
+	}
+	l.UnusedAmortizedUpfrontFeeForBillingPeriod, err = optionalFloat(record, headerIdx, "reservation/UnusedAmortizedUpfrontFeeForBillingPeriod")
+	if err != nil {
+		return nil, err
+	}
+	l.UnusedRecurringFee, err = optionalFloat(record, headerIdx, "reservation/UnusedRecurringFee")
+	if err != nil {
+		return nil, err
+	}
+	l.ReservationARN = optionalColumn(record, headerIdx, "reservation/ReservationARN")
+	l.Region = optionalColumn(record, headerIdx, "product/region")
+	return l, nil
+}
+
+// optionalColumn returns the value of a column that may be absent in some
+// exports, returning an empty string in that case
+func optionalColumn(record []string, headerIdx map[string]int, column string) string {
+	i, exists := headerIdx[column]
+	if !exists || i >= len(record) {
+		return ""
+	}
+	return record[i]
+}
+
+// optionalFloat parses a numeric column that may be absent or empty in some
+// exports, returning 0 in that case
+func optionalFloat(record []string, headerIdx map[string]int, column string) (float64, error) {
+	val := optionalColumn(record, headerIdx, column)
+	if val == "" {
+		return 0, nil
+	}
+	v, err := strconv.ParseFloat(val, 64)
+	if err != nil {
+		return 0, fmt.Errorf("Could not parse %s, %v", column, err)
+	}
+	return v, nil
+}
+
+// AddLineItem adds a line item to the report in start order. UIDs are unique
+// across the whole report, so if a line item with the same UID was already
+// added, even under a different start, the one with the later End is kept and
+// the other is dropped and logged.
+func (r *Report) AddLineItem(l *LineItem) {
+	if r.seen == nil {
+		r.seen = make(map[uint64]*LineItem)
+	}
+	if existing, dup := r.seen[l.UID]; dup {
+		r.logf("LineItemID, %d, already exists in Identity\n", l.UID)
+		if !l.End.After(existing.End) {
+			return
+		}
+		r.removeLineItem(existing)
+	}
+	r.seen[l.UID] = l
+	r.indexResource(l)
+	r.cache.invalidate()
+
+	if _, exists := r.LineItems[l.Start]; exists {
 		r.LineItems[l.Start] = append(r.LineItems[l.Start], l)
 		return
 	}
 
 	r.LineItems[l.Start] = []*LineItem{l}
 
-	// linearly scan to find where to put timepoint in sorted order
-	// most cases should be at the end
-	for j := len(r.TimePts) - 1; j >= 0; j-- {
-		if l.Start.After(r.TimePts[j]) {
-			// timestamp is after latest timestamp
-			if j == len(r.TimePts)-1 {
-				r.TimePts = append(r.TimePts, l.Start)
-				return
-			}
+	// binary search for where to put timepoint in sorted order, most cases
+	// should be at the end which needs no shifting
+	i := sort.Search(len(r.TimePts), func(i int) bool {
+		return r.TimePts[i].After(l.Start)
+	})
+	r.TimePts = append(r.TimePts, time.Time{})
+	copy(r.TimePts[i+1:], r.TimePts[i:])
+	r.TimePts[i] = l.Start
+}
 
-			// timestamp is somewhere in between
-			r.TimePts = append(r.TimePts, time.Time{})
-			copy(r.TimePts[j+2:], r.TimePts[j+1:])
-			r.TimePts[j+1] = l.Start
-			return
+// removeLineItem removes a line item from the report and its resource index
+func (r *Report) removeLineItem(l *LineItem) {
+	delete(r.seen, l.UID)
+	r.cache.invalidate()
+	if r.resourceIdx != nil {
+		r.resourceIdx[l.ResourceID] = removeItem(r.resourceIdx[l.ResourceID], l)
+		if len(r.resourceIdx[l.ResourceID]) == 0 {
+			delete(r.resourceIdx, l.ResourceID)
 		}
 	}
 
-	// timepoint is earlier than all timepoints
-	r.TimePts = append(r.TimePts, time.Time{})
-	copy(r.TimePts[1:], r.TimePts[:])
-	r.TimePts[0] = l.Start
-	return
+	items := removeItem(r.LineItems[l.Start], l)
+	if len(items) > 0 {
+		r.LineItems[l.Start] = items
+		return
+	}
+	delete(r.LineItems, l.Start)
+	i := sort.Search(len(r.TimePts), func(i int) bool {
+		return !r.TimePts[i].Before(l.Start)
+	})
+	if i < len(r.TimePts) && r.TimePts[i].Equal(l.Start) {
+		r.TimePts = append(r.TimePts[:i], r.TimePts[i+1:]...)
+	}
 }
 
-func (r Report) FilterByTime(s, e time.Time) []*LineItem {
-	endIdx := len(r.TimePts)
-	for i, itemStart := range r.TimePts {
-		if itemStart.After(e) {
-			endIdx = i
-			break
+// removeItem removes l from items, preserving the order of the rest
+func removeItem(items []*LineItem, l *LineItem) []*LineItem {
+	for i, item := range items {
+		if item == l {
+			return append(items[:i], items[i+1:]...)
+		}
+	}
+	return items
+}
+
+// logf logs through the report's Logger, falling back to the package logger
+func (r Report) logf(format string, v ...interface{}) {
+	if r.Logger != nil {
+		r.Logger.Printf(format, v...)
+		return
+	}
+	logger.Printf(format, v...)
+}
+
+// Merge adds the line items of other to the report through AddLineItem, so
+// they are kept in time order and duplicate UIDs are resolved by keeping the
+// line item with the later End. Line items are shared with other.
+func (r *Report) Merge(other *Report) {
+	if other.columns != nil {
+		r.addColumns(other.Columns())
+	}
+	for _, t := range other.TimePts {
+		for _, l := range other.LineItems[t] {
+			r.AddLineItem(l)
+		}
+	}
+}
+
+// Clone returns a deep copy of the report so that it can be mutated without
+// affecting the original. Line items and their tags are copied but Bills are
+// shared between the copies since they are never modified after parsing.
+func (r Report) Clone() *Report {
+	c := &Report{
+		LineItems:     make(map[time.Time][]*LineItem, len(r.LineItems)),
+		TimePts:       append([]time.Time(nil), r.TimePts...),
+		HighPrecision: r.HighPrecision,
+		ExcludeTax:    r.ExcludeTax,
+		PositiveOnly:  r.PositiveOnly,
+		NormalizeAZ:   r.NormalizeAZ,
+		InclusiveEnd:  r.InclusiveEnd,
+		Logger:        r.Logger,
+	}
+	for t, items := range r.LineItems {
+		cloned := make([]*LineItem, len(items))
+		for i, item := range items {
+			l := *item
+			if item.Tags != nil {
+				l.Tags = make(map[string]string, len(item.Tags))
+				for k, v := range item.Tags {
+					l.Tags[k] = v
+				}
+			}
+			cloned[i] = &l
+		}
+		c.LineItems[t] = cloned
+	}
+	if r.seen != nil {
+		c.seen = make(map[uint64]*LineItem, len(r.seen))
+		for _, items := range c.LineItems {
+			for _, item := range items {
+				c.seen[item.UID] = item
+			}
+		}
+	}
+	if r.columns != nil {
+		c.addColumns(r.Columns())
+	}
+	if r.resourceIdx != nil {
+		c.BuildResourceIndex()
+	}
+	return c
+}
+
+// TimeRange returns the earliest Start and latest End of the line items in the
+// report, which may be later than the last of TimePts, or zero times if the
+// report is empty
+func (r Report) TimeRange() (start, end time.Time) {
+	if len(r.TimePts) == 0 {
+		return time.Time{}, time.Time{}
+	}
+	start = r.TimePts[0]
+	for _, items := range r.LineItems {
+		for _, item := range items {
+			if item.End.After(end) {
+				end = item.End
+			}
 		}
 	}
+	return start, end
+}
+
+// FilterByTime returns the line items whose interval overlaps the half-open
+// window [s, e), i.e. that start before e and end after s. A line item
+// starting exactly at s is included while one starting exactly at e is not.
+// If InclusiveEnd is set the window is [s, e] and line items starting at e
+// are included as well.
+func (r Report) FilterByTime(s, e time.Time) []*LineItem {
+	endIdx := sort.Search(len(r.TimePts), func(i int) bool {
+		return !r.startsBefore(r.TimePts[i], e)
+	})
 	var l []*LineItem
 	for i := 0; i < endIdx; i++ {
 		items := r.LineItems[r.TimePts[i]]
@@ -157,46 +567,199 @@ func (r Report) FilterByTime(s, e time.Time) []*LineItem {
 	return l
 }
 
-func (r Report) GroupBy(fields []string, s, e time.Time) map[string]float64 {
+// ByType returns every line item of the report with the given
+// lineItem/LineItemType in time order. AWS uses the types Usage,
+// DiscountedUsage, SavingsPlanCoveredUsage, SavingsPlanNegation,
+// SavingsPlanRecurringFee, SavingsPlanUpfrontFee, RIFee, Fee, Tax, Credit,
+// Refund, EdpDiscount, PrivateRateDiscount, BundledDiscount and
+// DistributorDiscount.
+func (r Report) ByType(lineItemType string) []*LineItem {
+	var l []*LineItem
+	for _, t := range r.TimePts {
+		for _, item := range r.LineItems[t] {
+			if item.LineItemType == lineItemType {
+				l = append(l, item)
+			}
+		}
+	}
+	return l
+}
+
+// startsBefore returns whether a line item starting at t starts within a
+// window ending at e, honoring InclusiveEnd
+func (r Report) startsBefore(t, e time.Time) bool {
+	if r.InclusiveEnd {
+		return !t.After(e)
+	}
+	return t.Before(e)
+}
+
+// FilterByField returns the line items in the window whose field equals value.
+// field may be any field name GroupBy accepts; unsupported fields match nothing.
+func (r Report) FilterByField(field, value string, s, e time.Time) []*LineItem {
+	var l []*LineItem
+	for _, item := range r.FilterByTime(s, e) {
+		if val, ok := r.fieldValue(item, field); ok && val == value {
+			l = append(l, item)
+		}
+	}
+	return l
+}
+
+// FilterByFieldIn returns the line items in the window whose field equals any
+// of values. Values are matched exactly and are case sensitive like the CUR
+// itself, so AmazonEC2 does not match amazonec2.
+func (r Report) FilterByFieldIn(field string, values []string, s, e time.Time) []*LineItem {
+	set := make(map[string]struct{}, len(values))
+	for _, value := range values {
+		set[value] = struct{}{}
+	}
+
+	var l []*LineItem
+	for _, item := range r.FilterByTime(s, e) {
+		val, ok := r.fieldValue(item, field)
+		if !ok {
+			continue
+		}
+		if _, exists := set[val]; exists {
+			l = append(l, item)
+		}
+	}
+	return l
+}
+
+// aggregateItems returns the line items in the window that count towards
+// aggregated totals, leaving out taxes if ExcludeTax is set and non-positive
+// costs if PositiveOnly is set
+func (r Report) aggregateItems(s, e time.Time) []*LineItem {
 	items := r.FilterByTime(s, e)
+	if !r.ExcludeTax && !r.PositiveOnly {
+		return items
+	}
+	kept := items[:0]
+	for _, item := range items {
+		if r.ExcludeTax && item.LineItemType == "Tax" {
+			continue
+		}
+		if r.PositiveOnly && item.UnblendedCost <= 0 {
+			continue
+		}
+		kept = append(kept, item)
+	}
+	return kept
+}
+
+func (r Report) GroupBy(fields []string, s, e time.Time) map[string]float64 {
+	return r.GroupByMetric(fields, s, e, MetricUnblendedCost)
+}
+
+// GroupByMetric groups like GroupBy but sums the chosen metric, e.g.
+// MetricBlendedCost or MetricUsageAmount, instead of the UnblendedCost
+func (r Report) GroupByMetric(fields []string, s, e time.Time, metric Metric) map[string]float64 {
+	if !metric.valid() {
+		r.logf("Unsupported metric to group by, %s\n", metric)
+		return nil
+	}
+	key := r.queryKey(fields, s, e, metric)
+	if res, cached := r.cache.get(key); cached {
+		return res
+	}
+	res := r.groupBy(fields, s, e, metric)
+	r.cache.put(key, res)
+	return res
+}
+
+func (r Report) groupBy(fields []string, s, e time.Time, metric Metric) map[string]float64 {
+	items := r.aggregateItems(s, e)
+	r.warnMixedCurrencies(items)
 	res := make(map[string]float64)
+	var precise map[string]*big.Float
+	if r.HighPrecision {
+		precise = make(map[string]*big.Float)
+	}
 	for _, item := range items {
-		var keyParts []string
-		for _, field := range fields {
-			switch field {
-			case "lineItem/LineItemType":
-				keyParts = append(keyParts, item.LineItemType)
-			case "lineItem/Operation":
-				keyParts = append(keyParts, item.Operation)
-			case "lineItem/ProductCode":
-				keyParts = append(keyParts, item.ProductCode)
-			case "lineItem/ResourceId":
-				keyParts = append(keyParts, item.ResourceID)
-			case "lineItem/TaxType":
-				keyParts = append(keyParts, item.TaxType)
-			case "lineItem/UsageAccountId":
-				keyParts = append(keyParts, item.UsageAccountID)
-			case "lineItem/UsageType":
-				keyParts = append(keyParts, item.UsageType)
-			case "bill/PayerAccountId":
-				keyParts = append(keyParts, strconv.FormatUint(item.Bill.PayerAccountID, 10))
-			default:
-				logger.Printf("Unsupported field to group by, %s\n", field)
-			}
+		key := r.groupKey(item, fields)
+		v, _ := metric.Value(item)
+		if precise != nil {
+			addPrecise(precise, key, v)
+		} else {
+			res[key] += v
 		}
-		key := strings.Join(keyParts, "_")
-		if item.UnblendedCost > 0 {
-			res[key] += item.UnblendedCost
+	}
+
+	for key, total := range precise {
+		res[key], _ = total.Float64()
+	}
+	return res
+}
+
+// groupKey joins the line item's values for fields into a group key
+func (r Report) groupKey(item *LineItem, fields []string) string {
+	var keyParts []string
+	for _, field := range fields {
+		val, ok := r.fieldValue(item, field)
+		if !ok {
+			r.logf("Unsupported field to group by, %s\n", field)
+			continue
 		}
+		keyParts = append(keyParts, val)
 	}
+	return strings.Join(keyParts, "_")
+}
 
+// GroupByTimeSeries groups like GroupBy and further sums each group's cost
+// into buckets of the given width by line item start. Buckets are truncated
+// relative to UTC so that daylight saving time does not shift them.
+func (r Report) GroupByTimeSeries(fields []string, s, e time.Time, bucket time.Duration) map[string]map[time.Time]float64 {
+	res := make(map[string]map[time.Time]float64)
+	items := r.aggregateItems(s, e)
+	r.warnMixedCurrencies(items)
+	for _, item := range items {
+		key := r.groupKey(item, fields)
+		if _, exists := res[key]; !exists {
+			res[key] = make(map[time.Time]float64)
+		}
+		res[key][item.Start.UTC().Truncate(bucket)] += item.UnblendedCost
+	}
 	return res
 }
 
+// GroupByMonth groups like GroupBy and further sums each group's cost by the
+// UTC calendar month of line item starts, keyed like 2020-05, as AWS invoices
+// are organized. Line items spanning a month boundary count towards the month
+// they start in.
+func (r Report) GroupByMonth(fields []string, s, e time.Time) map[string]map[string]float64 {
+	res := make(map[string]map[string]float64)
+	items := r.aggregateItems(s, e)
+	r.warnMixedCurrencies(items)
+	for _, item := range items {
+		key := r.groupKey(item, fields)
+		if _, exists := res[key]; !exists {
+			res[key] = make(map[string]float64)
+		}
+		res[key][item.Start.UTC().Format("2006-01")] += item.UnblendedCost
+	}
+	return res
+}
+
+// precision in bits used for HighPrecision totals, wide enough to hold sums of
+// float64 costs spanning many orders of magnitude exactly
+const precision = 512
+
+func addPrecise(totals map[string]*big.Float, key string, v float64) {
+	total, exists := totals[key]
+	if !exists {
+		total = new(big.Float).SetPrec(precision)
+		totals[key] = total
+	}
+	total.Add(total, big.NewFloat(v))
+}
+
 type LineItem struct {
-	UID   uint64
-	Start time.Time
-	End   time.Time
+	UID        uint64
+	LineItemID string // raw identity/LineItemId that UID is hashed from
+	Start      time.Time
+	End        time.Time
 
 	AvailabilityZone    string
 	BlendedCost         float64
@@ -213,31 +776,118 @@ type LineItem struct {
 	UnblendedCost       float64
 	UnblendedRate       float64
 	UsageAccountID      string
-	UsageAmount         int
+	UsageAmount         float64
 	UsageEndDate        time.Time
 	UsageStartDate      time.Time
 	UsageType           string
 
+	PublicOnDemandCost                        float64
+	SavingsPlanEffectiveCost                  float64
+	AmortizedUpfrontFeeForBillingPeriod       float64
+	AmortizedUpfrontCostForUsage              float64 // reservation/AmortizedUpfrontCostForUsage
+	RecurringFeeForUsage                      float64 // reservation/RecurringFeeForUsage
+	ReservationEffectiveCost                  float64 // reservation/EffectiveCost
+	UnusedAmortizedUpfrontFeeForBillingPeriod float64 // reservation/UnusedAmortizedUpfrontFeeForBillingPeriod
+	UnusedRecurringFee                        float64 // reservation/UnusedRecurringFee
+	ReservationARN                            string
+	Region                                    string  // product/region
+	ReportedAmortizedCost                     float64 // amortized cost provided directly by reshaped exports
+
+	// Tags holds the non-empty cost allocation tag columns of the line item
+	// keyed by column name, e.g. resourceTags/user:Team
+	Tags map[string]string
+
 	Bill *Bill
 }
 
+// FieldValue returns the value of the named CUR column, resource tag or
+// registered derived field for the line item. The boolean is false if the
+// field is not supported.
+func (l *LineItem) FieldValue(field string) (string, bool) {
+	switch field {
+	case "identity/LineItemId":
+		return l.LineItemID, true
+	case "lineItem/AvailabilityZone":
+		return l.AvailabilityZone, true
+	case "lineItem/CurrencyCode":
+		return l.CurrencyCode, true
+	case "lineItem/LegalEntity":
+		return l.LegalEntity, true
+	case "lineItem/LineItemDescription":
+		return l.LineItemDescription, true
+	case "lineItem/LineItemType":
+		return l.LineItemType, true
+	case "lineItem/Operation":
+		return l.Operation, true
+	case "lineItem/ProductCode":
+		return l.ProductCode, true
+	case "lineItem/ResourceId":
+		return l.ResourceID, true
+	case "lineItem/TaxType":
+		return l.TaxType, true
+	case "lineItem/UsageAccountId":
+		return l.UsageAccountID, true
+	case "lineItem/UsageType":
+		return l.UsageType, true
+	case "product/region":
+		return l.Region, true
+	case "reservation/ReservationARN":
+		return l.ReservationARN, true
+	case "bill/PayerAccountId", "bill/InvoiceId", "bill/BillType":
+		// line items built with NewLineItem have no bill and group under an
+		// empty value
+		if l.Bill == nil {
+			return "", true
+		}
+		switch field {
+		case "bill/PayerAccountId":
+			return l.Bill.PayerAccountID, true
+		case "bill/InvoiceId":
+			return l.Bill.InvoiceID, true
+		default:
+			return l.Bill.BillType, true
+		}
+	}
+	if strings.HasPrefix(field, tagPrefix) {
+		// line items without the tag group under an empty value
+		return l.Tags[field], true
+	}
+	if fn, exists := derivedField(field); exists {
+		return fn(l), true
+	}
+	return "", false
+}
+
 func NewLineItem(id, timeInterval, az, blendedCost, blendedRate, currencyCode, legalEntity,
+	lineItemDescription, lineItemType, normalizationFactor, operation, productCode,
+	resourceID, taxType, unblendedCost, unblendedRate, usageAccountID, usageAmount, usageStart,
+	usageEnd, usageType string) (*LineItem, error) {
+	return newLineItem(timeLayout, id, timeInterval, az, blendedCost, blendedRate, currencyCode,
+		legalEntity, lineItemDescription, lineItemType, normalizationFactor, operation, productCode,
+		resourceID, taxType, unblendedCost, unblendedRate, usageAccountID, usageAmount, usageStart,
+		usageEnd, usageType)
+}
+
+// newLineItem builds a line item like NewLineItem with its timestamps in
+// layout
+func newLineItem(layout, id, timeInterval, az, blendedCost, blendedRate, currencyCode, legalEntity,
 	lineItemDescription, lineItemType, normalizationFactor, operation, productCode,
 	resourceID, taxType, unblendedCost, unblendedRate, usageAccountID, usageAmount, usageStart,
 	usageEnd, usageType string) (*LineItem, error) {
 	l := new(LineItem)
 	l.UID = xxhash.Sum64String(id)
+	l.LineItemID = id
 	timeIntStr := strings.Split(timeInterval, "/")
 	if len(timeIntStr) != 2 {
 		return nil, fmt.Errorf("Invalid time interval, %s", timeInterval)
 	}
 
 	var err error
-	l.Start, err = time.Parse(timeLayout, timeIntStr[0])
+	l.Start, err = parseTimeLayout(layout, timeIntStr[0])
 	if err != nil {
 		return nil, fmt.Errorf("Could not parse start interval, %v", err)
 	}
-	l.End, err = time.Parse(timeLayout, timeIntStr[1])
+	l.End, err = parseTimeLayout(layout, timeIntStr[1])
 	if err != nil {
 		return nil, fmt.Errorf("Coult not parse end interval, %v", err)
 	}
@@ -248,7 +898,7 @@ func NewLineItem(id, timeInterval, az, blendedCost, blendedRate, currencyCode, l
 	}
 
 	l.BlendedRate, err = strconv.ParseFloat(blendedRate, 64)
-	if err != nil {
+	if err != nil && blendedRate != "" {
 		return nil, fmt.Errorf("Could not parse blendedRate, %v", err)
 	}
 
@@ -267,15 +917,28 @@ func NewLineItem(id, timeInterval, az, blendedCost, blendedRate, currencyCode, l
 		return nil, fmt.Errorf("Could not parse unblendedRate, %v", err)
 	}
 
-	l.UsageStartDate, err = time.Parse(timeLayout, usageStart)
-	if err != nil {
-		return nil, fmt.Errorf("Could not parse start interval, %v", err)
+	l.UsageAmount, err = strconv.ParseFloat(usageAmount, 64)
+	if err != nil && usageAmount != "" {
+		return nil, fmt.Errorf("Could not parse usageAmount, %v", err)
 	}
-	l.UsageEndDate, err = time.Parse(timeLayout, usageEnd)
-	if err != nil {
-		return nil, fmt.Errorf("Coult not parse end interval, %v", err)
+
+	// narrower exports omit the usage dates and rely on the time interval
+	l.UsageStartDate = l.Start
+	if usageStart != "" {
+		l.UsageStartDate, err = parseTimeLayout(layout, usageStart)
+		if err != nil {
+			return nil, fmt.Errorf("Could not parse start interval, %v", err)
+		}
+	}
+	l.UsageEndDate = l.End
+	if usageEnd != "" {
+		l.UsageEndDate, err = parseTimeLayout(layout, usageEnd)
+		if err != nil {
+			return nil, fmt.Errorf("Coult not parse end interval, %v", err)
+		}
 	}
 
+	l.AvailabilityZone = az
 	l.CurrencyCode = currencyCode
 	l.LegalEntity = legalEntity
 	l.LineItemDescription = lineItemDescription
@@ -284,6 +947,8 @@ func NewLineItem(id, timeInterval, az, blendedCost, blendedRate, currencyCode, l
 	l.ProductCode = productCode
 	l.ResourceID = resourceID
 	l.TaxType = taxType
+	l.UsageAccountID = usageAccountID
+	l.UsageType = usageType
 
 	return l, nil
 }
@@ -292,30 +957,31 @@ type Bill struct {
 	BillingEntity          string
 	BillType               string
 	InvoiceID              string
-	PayerAccountID         uint64
+	PayerAccountID         string // opaque 12 digit account id, which may have leading zeros
 	BillingPeriodEndDate   time.Time
 	BillingPeriodStartDate time.Time
 }
 
 func NewBill(entity, billType, invoiceID, payerAccountID, start, end string) (*Bill, error) {
+	return newBill(timeLayout, entity, billType, invoiceID, payerAccountID, start, end)
+}
+
+// newBill builds a bill like NewBill with its timestamps in layout
+func newBill(layout, entity, billType, invoiceID, payerAccountID, start, end string) (*Bill, error) {
 	b := new(Bill)
 
 	var err error
-	b.PayerAccountID, err = strconv.ParseUint(payerAccountID, 10, 64)
-	if err != nil {
-		return nil, fmt.Errorf("Unable to parse PayerAccountID, %s, %v", payerAccountID, err)
-	}
-
-	b.BillingPeriodStartDate, err = time.Parse(timeLayout, start)
+	b.BillingPeriodStartDate, err = parseTimeLayout(layout, start)
 	if err != nil {
 		return nil, fmt.Errorf("Could not parse start interval, %v", err)
 	}
-	b.BillingPeriodEndDate, err = time.Parse(timeLayout, end)
+	b.BillingPeriodEndDate, err = parseTimeLayout(layout, end)
 	if err != nil {
 		return nil, fmt.Errorf("Coult not parse end interval, %v", err)
 	}
 
 	b.BillingEntity = entity
+	b.PayerAccountID = payerAccountID
 	b.BillType = billType
 	b.InvoiceID = invoiceID
 
@@ -323,20 +989,163 @@ func NewBill(entity, billType, invoiceID, payerAccountID, start, end string) (*B
 }
 
 func main() {
-	filename := "/Users/aouyang/Downloads/ao-aws-1.csv.gz"
-	report, err := NewReport(filename)
+	if len(os.Args) > 1 && os.Args[1] == "inspect" {
+		inspect(os.Args[2:])
+		return
+	}
+
+	filename := flag.String("file", "", "path to gzipped CUR csv")
+	start := flag.String("start", "", "start of the reporting window in "+timeLayout+" format, defaults to unbounded")
+	end := flag.String("end", "", "exclusive end of the reporting window in "+timeLayout+" format, defaults to unbounded")
+	group := flag.String("group", "lineItem/ProductCode,lineItem/Operation", "comma separated fields to group by")
+	top := flag.Int("top", 0, "number of most expensive groups to output, all if 0")
+	minCost := flag.Float64("min-cost", 0, "roll groups with an absolute cost below this into an "+OtherKey+" group")
+	format := flag.String("format", "json", "output format, one of "+strings.Join(ExportFormats(), ", ")+
+		", where the grafana time series and ndjson line items ignore -top and -min-cost")
+	fieldsFile := flag.String("fields-file", "", "path of derived field definitions that can be grouped on, see RegisterFieldsFile")
+	layout := flag.String("time-layout", timeLayout, "Go time layout of the timestamps in -file")
+	cache := flag.String("cache", "", "path of a report cache used instead of parsing -file when newer, and rewritten otherwise")
+	progress := flag.Bool("progress", false, "print the number of rows parsed so far to stderr while loading")
+	listen := flag.String("listen", "", "address to serve grouped cost queries over HTTP on instead of printing once, e.g. :8080")
+	reload := flag.Duration("reload", 0, "how often to reparse -file while serving with -listen, never if 0")
+	flag.Parse()
+
+	if *filename == "" {
+		fmt.Fprintln(flag.CommandLine.Output(), "missing required -file flag")
+		flag.Usage()
+		os.Exit(2)
+	}
+	if _, err := NewExporter(*format, nil); err != nil {
+		fmt.Fprintf(flag.CommandLine.Output(), "unsupported -format, %s\n", *format)
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if *fieldsFile != "" {
+		if err := RegisterFieldsFile(*fieldsFile); err != nil {
+			logger.Fatal(err)
+		}
+	}
+
+	s, err := parseFlagTime(*start, time.Time{})
 	if err != nil {
 		logger.Fatal(err)
 	}
-	res := report.GroupBy(
-		[]string{
-			"lineItem/ProductCode",
-			"lineItem/Operation",
-		},
-		time.Date(2020, time.May, 1, 0, 0, 0, 0, time.UTC),
-		time.Date(2020, time.June, 1, 0, 0, 0, 0, time.UTC),
-	)
+	e, err := parseFlagTime(*end, maxTime)
+	if err != nil {
+		logger.Fatal(err)
+	}
+
+	opts := []Option{TimeLayout(*layout)}
+	if *progress {
+		opts = append(opts, Progress(100000, func(linesRead int) {
+			fmt.Fprintf(os.Stderr, "\rparsed %d rows", linesRead)
+		}))
+	}
 
-	out, _ := json.MarshalIndent(res, "", "  ")
-	fmt.Println(string(out))
+	if *listen != "" {
+		srv, err := NewServerCached(*filename, *cache, opts...)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		srv.MetricsFields = strings.Split(*group, ",")
+		srv.ReloadEvery = *reload
+		logger.Fatal(srv.ListenAndServe(*listen))
+	}
+
+	var report *Report
+	if *cache != "" {
+		report, err = NewReportCached(*filename, *cache, opts...)
+	} else {
+		report, err = NewReport(*filename, opts...)
+	}
+	if *progress {
+		fmt.Fprintln(os.Stderr)
+	}
+	if _, ok := err.(ParseErrors); ok {
+		logger.Println(err)
+	} else if err != nil {
+		logger.Fatal(err)
+	}
+	fields := strings.Split(*group, ",")
+	res := topN(RollUpBelow(report.GroupByFields(fields, s, e), *minCost), *top)
+
+	exporter, _ := NewExporter(*format, fields)
+	if re, ok := exporter.(ResultsExporter); ok {
+		err = re.ExportResults(os.Stdout, res)
+	} else {
+		err = exporter.Export(os.Stdout, *report, s, e)
+	}
+	if err != nil {
+		logger.Fatal(err)
+	}
+}
+
+// inspect implements the inspect subcommand, printing the columns of each CUR
+// csv in args and whether they can be grouped on without parsing any rows
+//
+//	go-awsbilling inspect report.csv.gz
+func inspect(args []string) {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: inspect FILE...")
+		os.Exit(2)
+	}
+	for _, filename := range args {
+		fh, err := os.Open(filename)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		headers, err := InspectHeaders(fh)
+		fh.Close()
+		if err != nil {
+			logger.Fatal(err)
+		}
+
+		if len(args) > 1 {
+			fmt.Printf("%s:\n", filename)
+		}
+		for _, header := range headers {
+			support := "not supported for GroupBy"
+			if isSupportedField(header) {
+				support = "supported for GroupBy"
+			}
+			fmt.Printf("%s\t%s\n", header, support)
+		}
+	}
+}
+
+// parseTime parses a CUR timestamp in timeLayout, falling back to RFC3339 for
+// exports with fractional seconds or numeric offsets. Times are returned in
+// UTC so that equal instants compare equal as map keys.
+func parseTime(v string) (time.Time, error) {
+	return parseTimeLayout(timeLayout, v)
+}
+
+// parseTimeLayout parses a timestamp like parseTime but in layout. Layouts
+// without a zone are read as UTC.
+func parseTimeLayout(layout, v string) (time.Time, error) {
+	t, err := time.Parse(layout, v)
+	if err == nil {
+		return t.UTC(), nil
+	}
+	t, rfcErr := time.Parse(time.RFC3339Nano, v)
+	if rfcErr != nil {
+		return time.Time{}, err
+	}
+	return t.UTC(), nil
+}
+
+// maxTime is the end of a reporting window left unbounded
+var maxTime = time.Date(9999, time.December, 31, 0, 0, 0, 0, time.UTC)
+
+// parseFlagTime parses a time flag like parseTime, returning def if it is unset
+func parseFlagTime(v string, def time.Time) (time.Time, error) {
+	if v == "" {
+		return def, nil
+	}
+	t, err := parseTime(v)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Could not parse time flag, %v", err)
+	}
+	return t, nil
 }
@@ -0,0 +1,531 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/csv"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testColumns are the columns written by testCSV unless others are given
+var testColumns = []string{
+	"identity/LineItemId",
+	"identity/TimeInterval",
+	"bill/InvoiceId",
+	"bill/Entity",
+	"bill/BillType",
+	"bill/PayerAccountId",
+	"bill/BillingPeriodStartDate",
+	"bill/BillingPeriodEndDate",
+	"lineItem/UsageAccountId",
+	"lineItem/LineItemType",
+	"lineItem/LegalEntity",
+	"lineItem/LineItemDescription",
+	"lineItem/ProductCode",
+	"lineItem/UsageType",
+	"lineItem/Operation",
+	"lineItem/ResourceId",
+	"lineItem/TaxType",
+	"lineItem/AvailabilityZone",
+	"lineItem/UsageAmount",
+	"lineItem/NormalizationFactor",
+	"lineItem/UnblendedRate",
+	"lineItem/CurrencyCode",
+	"lineItem/UnblendedCost",
+	"lineItem/BlendedCost",
+}
+
+// testDefaults are the values of a testCSV row not set by the test
+var testDefaults = map[string]string{
+	"identity/TimeInterval":       "2020-05-01T00:00:00Z/2020-05-01T01:00:00Z",
+	"bill/InvoiceId":              "inv1",
+	"bill/PayerAccountId":         "123456789012",
+	"bill/BillingPeriodStartDate": "2020-05-01T00:00:00Z",
+	"bill/BillingPeriodEndDate":   "2020-06-01T00:00:00Z",
+	"lineItem/UsageAccountId":     "111111111111",
+	"lineItem/LineItemType":       "Usage",
+	"lineItem/ProductCode":        "AmazonEC2",
+	"lineItem/UsageType":          "BoxUsage:m5.large",
+	"lineItem/Operation":          "RunInstances",
+	"lineItem/AvailabilityZone":   "us-east-1a",
+	"lineItem/CurrencyCode":       "USD",
+	"lineItem/UnblendedCost":      "1",
+	"lineItem/BlendedCost":        "1",
+	"cost":                        "1",
+	"cost_type":                   "unblended",
+}
+
+// testCSV returns a CUR csv with the given columns, testColumns if nil, and a
+// row for each of rows. Columns missing from a row take the testDefaults value
+// of their classic column and identity/LineItemId defaults to id1, id2, ... by
+// row.
+func testCSV(t testing.TB, columns []string, rows ...map[string]string) string {
+	t.Helper()
+	if columns == nil {
+		columns = testColumns
+	}
+	var buf bytes.Buffer
+	cw := csv.NewWriter(&buf)
+	cw.Write(columns)
+	for i, row := range rows {
+		record := make([]string, len(columns))
+		for j, column := range columns {
+			val, exists := row[column]
+			if !exists {
+				// CUR 2.0 columns take the default of their classic column
+				val = testDefaults[canonicalColumn(column)]
+				if canonicalColumn(column) == "identity/LineItemId" {
+					val = "id" + strconv.Itoa(i+1)
+				}
+			}
+			record[j] = val
+		}
+		cw.Write(record)
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+// parseTestCSV parses a csv built by testCSV, failing the test on any error
+func parseTestCSV(t *testing.T, data string, opts ...Option) *Report {
+	t.Helper()
+	r, err := NewReportFromReader(strings.NewReader(data), append([]Option{WithLogger(discardLogger)}, opts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+// discardLogger drops the notices of reports built by tests
+var discardLogger = log.New(ioutil.Discard, "", 0)
+
+// testStart is the start of the first hour of line items built by testItem
+var testStart = time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC)
+
+// testEnd is later than the end of every line item built by tests
+var testEnd = testStart.AddDate(1, 0, 0)
+
+// testItem returns an hour long USD Usage line item starting hour hours after
+// testStart with the same blended and unblended cost
+func testItem(uid uint64, hour int, product string, cost float64) *LineItem {
+	start := testStart.Add(time.Duration(hour) * time.Hour)
+	return &LineItem{
+		UID:            uid,
+		Start:          start,
+		End:            start.Add(time.Hour),
+		UsageStartDate: start,
+		UsageEndDate:   start.Add(time.Hour),
+		CurrencyCode:   "USD",
+		LineItemType:   "Usage",
+		ProductCode:    product,
+		BlendedCost:    cost,
+		UnblendedCost:  cost,
+		Bill:           &Bill{InvoiceID: "inv1"},
+	}
+}
+
+// newTestReport returns a report holding items
+func newTestReport(items ...*LineItem) *Report {
+	r := &Report{LineItems: make(map[time.Time][]*LineItem), Logger: discardLogger}
+	for _, item := range items {
+		r.AddLineItem(item)
+	}
+	return r
+}
+
+// BenchmarkNewReportFromReader reports the allocations of parsing line items
+// that share their low cardinality values
+func BenchmarkNewReportFromReader(b *testing.B) {
+	rows := make([]map[string]string, 1000)
+	for i := range rows {
+		rows[i] = map[string]string{"identity/LineItemId": "id" + strconv.Itoa(i)}
+	}
+	data := testCSV(b, nil, rows...)
+
+	b.ReportAllocs()
+	allocs := testing.AllocsPerRun(b.N, func() {
+		if _, err := NewReportFromReader(strings.NewReader(data), WithLogger(discardLogger)); err != nil {
+			b.Fatal(err)
+		}
+	})
+	b.ReportMetric(allocs/float64(len(rows)), "allocs/row")
+}
+
+func TestInternBills(t *testing.T) {
+	data := testCSV(t, nil, map[string]string{}, map[string]string{})
+
+	items := parseTestCSV(t, data).FilterByTime(testStart, testEnd)
+	if len(items) != 2 || items[0].Bill != items[1].Bill {
+		t.Error("expected line items of a report to share their bill")
+	}
+
+	var bills []*Bill
+	StreamLineItems(strings.NewReader(data), func(l *LineItem) error {
+		bills = append(bills, l.Bill)
+		return nil
+	})
+	if len(bills) != 2 || bills[0] == bills[1] {
+		t.Error("expected streamed line items not to be interned")
+	}
+}
+
+func TestFieldValueWithoutBill(t *testing.T) {
+	l, err := NewLineItem("id1", "2020-05-01T00:00:00Z/2020-05-01T01:00:00Z", "", "1", "", "USD", "",
+		"", "Usage", "", "RunInstances", "AmazonEC2", "", "", "1", "", "111111111111", "1", "", "", "BoxUsage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, field := range []string{"bill/PayerAccountId", "bill/InvoiceId", "bill/BillType"} {
+		if val, ok := l.FieldValue(field); !ok || val != "" {
+			t.Errorf("expected an empty %s for a line item without a bill but got %q, %v", field, val, ok)
+		}
+	}
+
+	r := newTestReport(l)
+	if res := r.GroupByFields([]string{"bill/InvoiceId"}, testStart, testEnd); len(res) != 1 {
+		t.Errorf("expected a single group but got %v", res)
+	}
+}
+
+func TestMissingUsageDates(t *testing.T) {
+	// testColumns has no lineItem/UsageStartDate or lineItem/UsageEndDate
+	items := parseTestCSV(t, testCSV(t, nil, map[string]string{})).FilterByTime(testStart, testEnd)
+	if len(items) != 1 {
+		t.Fatalf("expected 1 line item but got %d", len(items))
+	}
+	l := items[0]
+	if !l.UsageStartDate.Equal(l.Start) || !l.UsageEndDate.Equal(l.End) {
+		t.Errorf("expected the usage dates to default to %v-%v but got %v-%v", l.Start, l.End, l.UsageStartDate, l.UsageEndDate)
+	}
+}
+
+func TestQuotedComma(t *testing.T) {
+	// the description holds a comma which must not shift the cost columns
+	data := testCSV(t, nil, map[string]string{
+		"lineItem/LineItemDescription": "EC2, prev gen",
+		"lineItem/UnblendedCost":       "2.5",
+	})
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write([]byte(data))
+	gz.Close()
+	filename := filepath.Join(t.TempDir(), "cur.csv.gz")
+	if err := ioutil.WriteFile(filename, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewReport(filename, WithLogger(discardLogger))
+	if err != nil {
+		t.Fatal(err)
+	}
+	items := r.FilterByTime(testStart, testEnd)
+	if len(items) != 1 {
+		t.Fatalf("expected 1 line item but got %d", len(items))
+	}
+	if items[0].LineItemDescription != "EC2, prev gen" || items[0].UnblendedCost != 2.5 {
+		t.Errorf("expected EC2, prev gen at 2.5 but got %q at %v", items[0].LineItemDescription, items[0].UnblendedCost)
+	}
+}
+
+func TestCreditNetted(t *testing.T) {
+	data := testCSV(t, nil,
+		map[string]string{"lineItem/UnblendedCost": "20.00"},
+		map[string]string{"lineItem/LineItemType": "Credit", "lineItem/UnblendedCost": "-5.00"},
+	)
+	r := parseTestCSV(t, data)
+
+	fields := []string{"lineItem/ProductCode"}
+	if res := r.GroupBy(fields, testStart, testEnd); res["AmazonEC2"] != 15 {
+		t.Errorf("expected the credit to be netted to 15 but got %v", res)
+	}
+	r.PositiveOnly = true
+	if res := r.GroupBy(fields, testStart, testEnd); res["AmazonEC2"] != 20 {
+		t.Errorf("expected the credit to be dropped with PositiveOnly but got %v", res)
+	}
+}
+
+// shuffledTestItems returns n line items starting in distinct hours in a
+// random but repeatable order
+func shuffledTestItems(n int) []*LineItem {
+	items := make([]*LineItem, n)
+	for i, hour := range rand.New(rand.NewSource(1)).Perm(n) {
+		items[i] = testItem(uint64(i+1), hour, "AmazonEC2", 1)
+	}
+	return items
+}
+
+func TestAddLineItemOrder(t *testing.T) {
+	r := newTestReport(shuffledTestItems(1000)...)
+	if len(r.TimePts) != 1000 {
+		t.Fatalf("expected 1000 time points but got %d", len(r.TimePts))
+	}
+	for i := 1; i < len(r.TimePts); i++ {
+		if !r.TimePts[i-1].Before(r.TimePts[i]) {
+			t.Fatalf("expected sorted time points but got %v before %v", r.TimePts[i-1], r.TimePts[i])
+		}
+	}
+}
+
+func BenchmarkAddLineItemShuffled(b *testing.B) {
+	items := shuffledTestItems(100000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		newTestReport(items...)
+	}
+}
+
+// BenchmarkAddLineItemSameStart adds line items that all start in the same
+// hour, as in a daily granularity export, which previously scanned every line
+// item of the hour for duplicates
+func BenchmarkAddLineItemSameStart(b *testing.B) {
+	items := make([]*LineItem, 10000)
+	for i := range items {
+		items[i] = testItem(uint64(i+1), 0, "AmazonEC2", 1)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		newTestReport(items...)
+	}
+}
+
+func TestTimestampVariants(t *testing.T) {
+	expected := time.Date(2020, 5, 1, 1, 0, 0, 0, time.UTC)
+	for _, v := range []string{
+		"2020-05-01T01:00:00Z",
+		"2020-05-01T01:00:00.000Z",
+		"2020-05-01T01:00:00+00:00",
+		"2020-05-01T03:00:00+02:00",
+	} {
+		got, err := parseTime(v)
+		if err != nil {
+			t.Errorf("could not parse %s, %v", v, err)
+			continue
+		}
+		if !got.Equal(expected) || got.Location() != time.UTC {
+			t.Errorf("expected %s to parse as %v but got %v", v, expected, got)
+		}
+	}
+	if _, err := parseTime("2020-05-01"); err == nil {
+		t.Error("expected a date without a time to fail")
+	}
+
+	data := testCSV(t, nil, map[string]string{
+		"identity/TimeInterval": "2020-05-01T00:00:00.000Z/2020-05-01T01:00:00+00:00",
+	})
+	items := parseTestCSV(t, data).FilterByTime(testStart, testEnd)
+	if len(items) != 1 || !items[0].Start.Equal(testStart) || !items[0].End.Equal(expected) {
+		t.Errorf("expected a line item from %v to %v but got %v", testStart, expected, items)
+	}
+}
+
+func TestBOMAndCRLF(t *testing.T) {
+	data := testCSV(t, nil, map[string]string{}, map[string]string{})
+	data = "\ufeff" + strings.Replace(data, "\n", "\r\n", -1)
+
+	r := parseTestCSV(t, data)
+	if !r.HasColumn("identity/LineItemId") || !r.HasColumn("lineItem/BlendedCost") {
+		t.Errorf("expected the first and last columns to be named without the BOM or carriage return, %v", r.Columns())
+	}
+	items := r.FilterByTime(testStart, testEnd)
+	if len(items) != 2 || items[0].LineItemID != "id1" || items[1].BlendedCost != 1 {
+		t.Errorf("expected 2 line items but got %v", items)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	a := newTestReport(testItem(1, 0, "AmazonEC2", 1), testItem(2, 1, "AmazonEC2", 2))
+	// b overlaps a with a restated line item 2 spanning a longer interval
+	restated := testItem(2, 1, "AmazonEC2", 3)
+	restated.End = restated.End.Add(time.Hour)
+	b := newTestReport(restated, testItem(3, 2, "AmazonS3", 4))
+
+	a.Merge(b)
+	items := a.FilterByTime(testStart, testEnd)
+	if len(items) != 3 {
+		t.Fatalf("expected 3 line items but got %d", len(items))
+	}
+	if items[1] != restated {
+		t.Errorf("expected the line item with the later end to be kept but got %+v", items[1])
+	}
+	if total := a.AmortizedTotal(testStart, testEnd); total != 8 {
+		t.Errorf("expected a merged total of 8 but got %v", total)
+	}
+	if len(a.TimePts) != 3 {
+		t.Errorf("expected 3 time points but got %v", a.TimePts)
+	}
+}
+
+// captureLogger records the notices of a report
+type captureLogger struct {
+	msgs []string
+}
+
+func (c *captureLogger) Printf(format string, v ...interface{}) {
+	c.msgs = append(c.msgs, fmt.Sprintf(format, v...))
+}
+
+func TestWithLogger(t *testing.T) {
+	data := testCSV(t, nil,
+		map[string]string{"identity/LineItemId": "id1"},
+		map[string]string{"identity/LineItemId": "id1"},
+	)
+	logs := &captureLogger{}
+	r := parseTestCSV(t, data, WithLogger(logs))
+	if len(logs.msgs) != 1 || !strings.Contains(logs.msgs[0], "already exists") {
+		t.Errorf("expected the duplicate line item to be logged to the custom logger but got %q", logs.msgs)
+	}
+
+	r.GroupBy([]string{"bogus/Field"}, testStart, testEnd)
+	if len(logs.msgs) != 2 || !strings.Contains(logs.msgs[1], "bogus/Field") {
+		t.Errorf("expected the unsupported field to be logged to the custom logger but got %q", logs.msgs)
+	}
+}
+
+func TestFilterByTimeBoundaries(t *testing.T) {
+	// items start at hours 0, 1, 2 and 3 and the window is hours 1 to 3
+	r := newTestReport(
+		testItem(1, 0, "AmazonEC2", 1),
+		testItem(2, 1, "AmazonEC2", 1),
+		testItem(3, 2, "AmazonEC2", 1),
+		testItem(4, 3, "AmazonEC2", 1),
+	)
+	s, e := testStart.Add(time.Hour), testStart.Add(3*time.Hour)
+
+	tests := []struct {
+		inclusiveEnd bool
+		expected     []uint64
+	}{
+		{false, []uint64{2, 3}},
+		{true, []uint64{2, 3, 4}},
+	}
+	for _, test := range tests {
+		r.InclusiveEnd = test.inclusiveEnd
+		var uids []uint64
+		for _, item := range r.FilterByTime(s, e) {
+			uids = append(uids, item.UID)
+		}
+		if !reflect.DeepEqual(uids, test.expected) {
+			t.Errorf("expected %v with InclusiveEnd %v but got %v", test.expected, test.inclusiveEnd, uids)
+		}
+	}
+}
+
+func TestDuplicateAcrossTimestamps(t *testing.T) {
+	// the same line item restated with a later start and end
+	first := testItem(1, 0, "AmazonEC2", 1)
+	restated := testItem(1, 5, "AmazonEC2", 2)
+	r := newTestReport(first, restated)
+
+	items := r.FilterByTime(testStart, testEnd)
+	if len(items) != 1 || items[0] != restated {
+		t.Errorf("expected only the restated line item to be kept but got %v", items)
+	}
+	if _, exists := r.LineItems[first.Start]; exists || len(r.TimePts) != 1 {
+		t.Errorf("expected the first start to be dropped but got %v", r.TimePts)
+	}
+
+	// an earlier copy added afterwards is ignored
+	r.AddLineItem(testItem(1, 0, "AmazonEC2", 1))
+	if items := r.FilterByTime(testStart, testEnd); len(items) != 1 || items[0] != restated {
+		t.Errorf("expected the earlier copy to be ignored but got %v", items)
+	}
+}
+
+func TestMultiMemberGzip(t *testing.T) {
+	// the header and first row, then the second row, each in their own member
+	data := testCSV(t, nil, map[string]string{}, map[string]string{})
+	split := strings.Index(data, "\nid2") + 1
+	var buf bytes.Buffer
+	for _, member := range []string{data[:split], data[split:]} {
+		gz := gzip.NewWriter(&buf)
+		gz.Write([]byte(member))
+		gz.Close()
+	}
+
+	r, err := NewReportFromReader(&buf, WithLogger(discardLogger))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if items := r.FilterByTime(testStart, testEnd); len(items) != 2 {
+		t.Errorf("expected the line items of both members but got %d", len(items))
+	}
+}
+
+func TestNewReportClosesFile(t *testing.T) {
+	if _, err := ioutil.ReadDir("/proc/self/fd"); err != nil {
+		t.Skip("open files cannot be counted on this platform")
+	}
+	openFiles := func() int {
+		fds, _ := ioutil.ReadDir("/proc/self/fd")
+		return len(fds)
+	}
+
+	// a strict parse fails on the second row, part way through the file
+	data := testCSV(t, nil, map[string]string{}, map[string]string{"lineItem/UnblendedCost": "x"}, map[string]string{})
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write([]byte(data))
+	gz.Close()
+	filename := filepath.Join(t.TempDir(), "cur.csv.gz")
+	if err := ioutil.WriteFile(filename, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	before := openFiles()
+	if _, err := NewReport(filename, Strict(), WithLogger(discardLogger)); err == nil {
+		t.Fatal("expected the malformed row to fail a strict parse")
+	}
+	if after := openFiles(); after != before {
+		t.Errorf("expected the file to be closed after a failed parse but %d files are open, %d before", after, before)
+	}
+}
+
+func TestCloseOnReturn(t *testing.T) {
+	closeErr := fmt.Errorf("close failed")
+	failClose := func() error { return closeErr }
+	parseErr := ParseErrors{{Row: 1}}
+	scanErr := fmt.Errorf("scan failed")
+
+	tests := []struct {
+		err, expected error
+	}{
+		{nil, closeErr},
+		{parseErr, closeErr},
+		{scanErr, scanErr},
+	}
+	for _, test := range tests {
+		r, err := &Report{}, test.err
+		closeOnReturn(failClose, &r, &err)
+		if err != test.expected {
+			t.Errorf("expected %v after closing with %v but got %v", test.expected, test.err, err)
+		}
+		if test.expected == closeErr && r != nil {
+			t.Errorf("expected the report to be discarded when closing fails after %v", test.err)
+		}
+	}
+}
+
+func TestLeadingZeroAccountIDs(t *testing.T) {
+	data := testCSV(t, nil, map[string]string{
+		"bill/PayerAccountId":     "012345678901",
+		"lineItem/UsageAccountId": "000123456789",
+	})
+	r := parseTestCSV(t, data)
+
+	res := r.GroupByFields([]string{"bill/PayerAccountId", "lineItem/UsageAccountId"}, testStart, testEnd)
+	if len(res) != 1 || res[0].Values[0] != "012345678901" || res[0].Values[1] != "000123456789" {
+		t.Errorf("expected the account ids to keep their leading zeros but got %+v", res)
+	}
+}
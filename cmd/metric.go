@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Metric is a numeric line item field that can be aggregated
+type Metric string
+
+const (
+	MetricUnblendedCost Metric = "UnblendedCost"
+	MetricBlendedCost   Metric = "BlendedCost"
+	MetricAmortizedCost Metric = "AmortizedCost"
+	MetricUsageAmount   Metric = "UsageAmount"
+
+	// commitment amortization columns, zero when absent from the export
+	MetricSavingsPlanEffectiveCost     Metric = "SavingsPlanEffectiveCost"
+	MetricReservationEffectiveCost     Metric = "ReservationEffectiveCost"
+	MetricAmortizedUpfrontCostForUsage Metric = "AmortizedUpfrontCostForUsage"
+	MetricRecurringFeeForUsage         Metric = "RecurringFeeForUsage"
+)
+
+// Value returns the metric's value for a line item
+func (m Metric) Value(l *LineItem) (float64, error) {
+	switch m {
+	case MetricUnblendedCost:
+		return l.UnblendedCost, nil
+	case MetricBlendedCost:
+		return l.BlendedCost, nil
+	case MetricAmortizedCost:
+		return l.AmortizedCost(), nil
+	case MetricUsageAmount:
+		return l.UsageAmount, nil
+	case MetricSavingsPlanEffectiveCost:
+		return l.SavingsPlanEffectiveCost, nil
+	case MetricReservationEffectiveCost:
+		return l.ReservationEffectiveCost, nil
+	case MetricAmortizedUpfrontCostForUsage:
+		return l.AmortizedUpfrontCostForUsage, nil
+	case MetricRecurringFeeForUsage:
+		return l.RecurringFeeForUsage, nil
+	}
+	return 0, fmt.Errorf("Unsupported metric, %s", m)
+}
+
+// valid returns whether the metric is supported
+func (m Metric) valid() bool {
+	_, err := m.Value(&LineItem{})
+	return err == nil
+}
+
+// setCost routes a cost from an export with separate cost and cost_type
+// columns to the line item field for costType, which is one of blended,
+// unblended or amortized in any case and optionally suffixed by cost
+func (l *LineItem) setCost(costType, cost string) error {
+	v, err := strconv.ParseFloat(cost, 64)
+	if err != nil {
+		return fmt.Errorf("Could not parse cost, %v", err)
+	}
+	normalized := strings.ToLower(costType)
+	normalized = strings.TrimSuffix(strings.TrimSuffix(normalized, "cost"), "_")
+	switch normalized {
+	case "blended":
+		l.BlendedCost = v
+	case "unblended":
+		l.UnblendedCost = v
+	case "amortized":
+		l.ReportedAmortizedCost = v
+	default:
+		return fmt.Errorf("Unsupported cost_type, %s", costType)
+	}
+	return nil
+}
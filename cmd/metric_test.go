@@ -0,0 +1,78 @@
+package main
+
+import "testing"
+
+// metricTestCSV returns a report with a line item of each commitment type
+// whose metrics all differ
+func metricTestCSV(t *testing.T) string {
+	columns := append(append([]string(nil), testColumns...),
+		"savingsPlan/SavingsPlanEffectiveCost",
+		"reservation/EffectiveCost",
+		"reservation/AmortizedUpfrontFeeForBillingPeriod",
+		"reservation/AmortizedUpfrontCostForUsage",
+		"reservation/RecurringFeeForUsage",
+		"reservation/UnusedAmortizedUpfrontFeeForBillingPeriod",
+		"reservation/UnusedRecurringFee",
+	)
+	return testCSV(t, columns,
+		map[string]string{
+			"lineItem/UnblendedCost": "10",
+			"lineItem/BlendedCost":   "9",
+			"lineItem/UsageAmount":   "1",
+		},
+		map[string]string{
+			"lineItem/LineItemType":                "SavingsPlanCoveredUsage",
+			"lineItem/UnblendedCost":               "20",
+			"lineItem/BlendedCost":                 "18",
+			"lineItem/UsageAmount":                 "2",
+			"savingsPlan/SavingsPlanEffectiveCost": "12",
+		},
+		map[string]string{
+			"lineItem/LineItemType":                                 "RIFee",
+			"lineItem/UnblendedCost":                                "20",
+			"lineItem/BlendedCost":                                  "20",
+			"reservation/AmortizedUpfrontFeeForBillingPeriod":       "30",
+			"reservation/UnusedAmortizedUpfrontFeeForBillingPeriod": "27",
+			"reservation/UnusedRecurringFee":                        "18",
+		},
+		map[string]string{
+			"lineItem/LineItemType":                    "DiscountedUsage",
+			"lineItem/UnblendedCost":                   "0",
+			"lineItem/BlendedCost":                     "0",
+			"lineItem/UsageAmount":                     "4",
+			"reservation/EffectiveCost":                "5",
+			"reservation/AmortizedUpfrontCostForUsage": "3",
+			"reservation/RecurringFeeForUsage":         "2",
+		},
+	)
+}
+
+func TestGroupByMetric(t *testing.T) {
+	r := parseTestCSV(t, metricTestCSV(t))
+	fields := []string{"lineItem/ProductCode"}
+
+	tests := []struct {
+		metric   Metric
+		expected float64
+	}{
+		{MetricUnblendedCost, 50},
+		{MetricBlendedCost, 47},
+		// the RIFee only adds the unused part of the reservation to the
+		// upfront and recurring fees in the effective cost of its usage
+		{MetricAmortizedCost, 10 + 12 + 27 + 18 + 5},
+		{MetricUsageAmount, 7},
+		{MetricSavingsPlanEffectiveCost, 12},
+		{MetricReservationEffectiveCost, 5},
+		{MetricAmortizedUpfrontCostForUsage, 3},
+		{MetricRecurringFeeForUsage, 2},
+	}
+	for _, test := range tests {
+		if res := r.GroupByMetric(fields, testStart, testEnd, test.metric); res["AmazonEC2"] != test.expected {
+			t.Errorf("expected %s of %v but got %v", test.metric, test.expected, res)
+		}
+	}
+
+	if res := r.GroupByMetric(fields, testStart, testEnd, Metric("bogus")); res != nil {
+		t.Errorf("expected no result for an unsupported metric but got %v", res)
+	}
+}
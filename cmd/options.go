@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// Option configures how a report is parsed
+type Option func(*options)
+
+// options holds the parse settings applied by Option
+type options struct {
+	ctx        context.Context // checked between batches of rows to abort the parse
+	strict     bool
+	workers    int
+	timeLayout string
+	logger     Logger
+	progress   *progress
+
+	// stream is set by the streaming APIs, which pass on each line item as
+	// soon as it is parsed instead of holding them in a report
+	stream bool
+	// limit stops the scan once this many rows have been read, if positive
+	limit int
+}
+
+// Strict aborts parsing on the first malformed row instead of skipping it and
+// collecting the failure into ParseErrors
+func Strict() Option {
+	return func(o *options) {
+		o.strict = true
+	}
+}
+
+// Workers sets how many goroutines parse rows concurrently, defaulting to
+// GOMAXPROCS. Line items are still added to the report in file order.
+func Workers(n int) Option {
+	return func(o *options) {
+		o.workers = n
+	}
+}
+
+// WithLogger sets the Logger of the parsed report, which also receives the
+// notices logged while parsing
+func WithLogger(l Logger) Option {
+	return func(o *options) {
+		o.logger = l
+	}
+}
+
+// Progress calls fn with the total number of csv rows read every n rows, e.g.
+// to show that parsing a large report is moving. Rows are counted across all
+// files of a zip or manifest, decompressed and before any are skipped.
+func Progress(n int, fn func(linesRead int)) Option {
+	return func(o *options) {
+		if n > 0 && fn != nil {
+			o.progress = &progress{every: n, fn: fn}
+		}
+	}
+}
+
+// progress counts the rows read by a parse for the Progress option. It is
+// shared by the files of a directory parsed concurrently.
+type progress struct {
+	every int
+	fn    func(linesRead int)
+
+	mu    sync.Mutex
+	lines int
+}
+
+// add counts a row, calling fn on every n-th one. It is a no-op on a nil
+// progress so that callers need not check whether the option was set.
+func (p *progress) add() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.lines++
+	if p.lines%p.every == 0 {
+		p.fn(p.lines)
+	}
+}
+
+// TimeLayout sets the time.Parse layout of the timestamps in the CUR,
+// defaulting to 2006-01-02T15:04:05Z, for exports such as those reshaped by
+// other tools that write e.g. 2006-01-02 15:04:05. RFC 3339 timestamps are
+// accepted regardless and timestamps without a zone are read as UTC.
+func TimeLayout(layout string) Option {
+	return func(o *options) {
+		o.timeLayout = layout
+	}
+}
+
+// newOptions applies opts over the default parse settings
+func newOptions(opts []Option) options {
+	o := options{ctx: context.Background(), workers: runtime.GOMAXPROCS(0), timeLayout: timeLayout}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// newReport returns an empty report configured by the options
+func (o options) newReport() *Report {
+	return &Report{LineItems: make(map[time.Time][]*LineItem), Logger: o.logger}
+}
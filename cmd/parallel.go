@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/csv"
+	"io"
+)
+
+// batchRows is how many csv records a worker parses at a time. Cancellation
+// is checked between batches.
+const batchRows = 1024
+
+// rowBatch is a run of consecutive csv records and the line items parsed from
+// them
+type rowBatch struct {
+	records [][]string
+	items   []*LineItem
+	errs    []error       // error reading or parsing each record
+	readErr error         // error other than a malformed row that ended reading
+	parsed  chan struct{} // closed once items and errs are filled in
+}
+
+// parseBatches reads the remaining records of cr in batches and parses them
+// into line items on the given number of worker goroutines. Batches are sent
+// on the returned channel in file order and must be waited on through parsed
+// before use. Closing stop ends reading early; the returned channel is closed
+// once reading stops and should be drained so the reader can exit.
+func parseBatches(cr *csv.Reader, workers int, stop <-chan struct{}, parse func(record []string) (*LineItem, error)) <-chan *rowBatch {
+	if workers < 1 {
+		workers = 1
+	}
+	work := make(chan *rowBatch, workers)
+	out := make(chan *rowBatch, 2*workers)
+
+	for i := 0; i < workers; i++ {
+		go func() {
+			for b := range work {
+				b.items = make([]*LineItem, len(b.records))
+				for j, record := range b.records {
+					if b.errs[j] == nil {
+						b.items[j], b.errs[j] = parse(record)
+					}
+				}
+				close(b.parsed)
+			}
+		}()
+	}
+
+	go func() {
+		defer close(out)
+		defer close(work)
+		for {
+			b := &rowBatch{parsed: make(chan struct{})}
+			for len(b.records) < batchRows {
+				record, err := cr.Read()
+				if err == io.EOF {
+					break
+				}
+				if _, malformed := err.(*csv.ParseError); err != nil && !malformed {
+					b.readErr = err
+					break
+				}
+				b.records = append(b.records, record)
+				b.errs = append(b.errs, err)
+			}
+			if len(b.records) == 0 && b.readErr == nil {
+				return
+			}
+
+			select {
+			case work <- b:
+			case <-stop:
+				return
+			}
+			select {
+			case out <- b:
+			case <-stop:
+				return
+			}
+			if b.readErr != nil || len(b.records) < batchRows {
+				return
+			}
+		}
+	}()
+	return out
+}
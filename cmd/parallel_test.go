@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// parallelTestCSV returns a csv of n rows each starting in its own hour
+func parallelTestCSV(tb testing.TB, n int) string {
+	rows := make([]map[string]string, n)
+	for i := range rows {
+		start := testStart.Add(time.Duration(i) * time.Hour)
+		rows[i] = map[string]string{
+			"identity/TimeInterval": start.Format(timeLayout) + "/" + start.Add(time.Hour).Format(timeLayout),
+		}
+	}
+	return testCSV(tb, nil, rows...)
+}
+
+func TestWorkersFileOrder(t *testing.T) {
+	// spans several batches so that workers finish out of order, while every
+	// row starts in the same hour whose line items are kept in the order added
+	n := 5*batchRows + 1
+	rows := make([]map[string]string, n)
+	for i := range rows {
+		rows[i] = map[string]string{}
+	}
+	r := parseTestCSV(t, testCSV(t, nil, rows...), Workers(4))
+
+	items := r.LineItems[testStart]
+	if len(items) != n {
+		t.Fatalf("expected %d line items but got %d", n, len(items))
+	}
+	for i, l := range items {
+		if expected := "id" + strconv.Itoa(i+1); l.LineItemID != expected {
+			t.Fatalf("expected %s but got %s", expected, l.LineItemID)
+		}
+	}
+}
+
+func TestNewReportFromDir(t *testing.T) {
+	dir := t.TempDir()
+	n := 6
+	for i := 0; i < n; i++ {
+		rows := []map[string]string{{"identity/LineItemId": "id" + strconv.Itoa(i)}}
+		if i == 3 {
+			rows = append(rows, map[string]string{"identity/LineItemId": "bad", "lineItem/UnblendedCost": "x"})
+		}
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		gz.Write([]byte(testCSV(t, nil, rows...)))
+		gz.Close()
+		filename := filepath.Join(dir, fmt.Sprintf("cur-%d.csv.gz", i))
+		if err := ioutil.WriteFile(filename, buf.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r, err := NewReportFromDir(dir, Workers(2), WithLogger(discardLogger))
+	perrs, ok := err.(ParseErrors)
+	if !ok || len(perrs) != 1 {
+		t.Fatalf("expected the malformed row to be reported but got %v", err)
+	}
+	if perrs[0].File != "cur-3.csv.gz" {
+		t.Errorf("expected the error to name cur-3.csv.gz but got %q", perrs[0].File)
+	}
+	items := r.LineItems[testStart]
+	if len(items) != n {
+		t.Fatalf("expected %d line items but got %d", n, len(items))
+	}
+	for i, l := range items {
+		if expected := "id" + strconv.Itoa(i); l.LineItemID != expected {
+			t.Errorf("expected the files merged in name order with %s but got %s", expected, l.LineItemID)
+		}
+	}
+
+	if _, err := NewReportFromDir(dir, Workers(2), Strict(), WithLogger(discardLogger)); err == nil {
+		t.Error("expected the malformed row to fail a strict parse")
+	}
+}
+
+func BenchmarkWorkers(b *testing.B) {
+	data := parallelTestCSV(b, 20*batchRows)
+	for _, workers := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := NewReportFromReader(strings.NewReader(data), Workers(workers), WithLogger(discardLogger)); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"io"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// ParquetExporter writes grouped costs as a Parquet file with one column per
+// grouped field
+type ParquetExporter struct {
+	Fields []string
+}
+
+func (p ParquetExporter) Export(w io.Writer, r Report, s, e time.Time) error {
+	return p.ExportResults(w, r.GroupByFields(p.Fields, s, e))
+}
+
+// ExportResults writes grouped results as a Parquet file. Each grouped field
+// is its own UTF8 column alongside the cost and pct as double columns. Columns
+// are ordered by name as Parquet groups are.
+func (p ParquetExporter) ExportResults(w io.Writer, results []GroupResult) error {
+	fields := supportedFields(p.Fields)
+	group := parquet.Group{
+		"cost": parquet.Leaf(parquet.DoubleType),
+		"pct":  parquet.Leaf(parquet.DoubleType),
+	}
+	for _, field := range fields {
+		group[field] = parquet.String()
+	}
+	schema := parquet.NewSchema("cost", group)
+
+	// leaf column index of each grouped field, cost and pct
+	idx := make(map[string]int, len(group))
+	for i, path := range schema.Columns() {
+		idx[path[0]] = i
+	}
+
+	rows := make([]parquet.Row, len(results))
+	for i, res := range results {
+		row := make(parquet.Row, len(group))
+		for j, field := range fields {
+			var val string
+			if j < len(res.Values) {
+				val = res.Values[j]
+			}
+			row[idx[field]] = parquet.ValueOf(val).Level(0, 0, idx[field])
+		}
+		row[idx["cost"]] = parquet.ValueOf(res.Cost).Level(0, 0, idx["cost"])
+		row[idx["pct"]] = parquet.ValueOf(res.Pct).Level(0, 0, idx["pct"])
+		rows[i] = row
+	}
+
+	pw := parquet.NewWriter(w, schema)
+	if _, err := pw.WriteRows(rows); err != nil {
+		return err
+	}
+	return pw.Close()
+}
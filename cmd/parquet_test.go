@@ -0,0 +1,37 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+func TestParquetExporter(t *testing.T) {
+	r := newTestReport(testItem(1, 0, "AmazonEC2", 3), testItem(2, 0, "AmazonS3", 1))
+	fields := []string{"lineItem/ProductCode", "bogus/Field"}
+
+	var buf bytes.Buffer
+	if err := (ParquetExporter{Fields: fields}).Export(&buf, *r, testStart, testEnd); err != nil {
+		t.Fatal(err)
+	}
+
+	type row struct {
+		ProductCode string  `parquet:"lineItem/ProductCode"`
+		Cost        float64 `parquet:"cost"`
+		Pct         float64 `parquet:"pct"`
+	}
+	rows, err := parquet.Read[row](bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []row{{"AmazonEC2", 3, 75}, {"AmazonS3", 1, 25}}
+	if len(rows) != len(expected) {
+		t.Fatalf("expected %v but got %v", expected, rows)
+	}
+	for i := range expected {
+		if rows[i] != expected[i] {
+			t.Errorf("expected %+v but got %+v", expected[i], rows[i])
+		}
+	}
+}
@@ -0,0 +1,562 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"sort"
+	"strings"
+	"time"
+)
+
+// TopLineItems returns the n line items with the highest UnblendedCost in the
+// window sorted in descending order. Ties are broken by UID. If n <= 0 all
+// line items in the window are returned.
+func (r Report) TopLineItems(n int, s, e time.Time) []*LineItem {
+	items := r.FilterByTime(s, e)
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].UnblendedCost != items[j].UnblendedCost {
+			return items[i].UnblendedCost > items[j].UnblendedCost
+		}
+		return items[i].UID < items[j].UID
+	})
+	if n > 0 && n < len(items) {
+		items = items[:n]
+	}
+	return items
+}
+
+// AccountSummary sums the UnblendedCost in the window per UsageAccountId.
+// Summing across currencies is invalid, so an error identifying the account and
+// its currencies is returned if an account has line items in more than one
+// CurrencyCode. If rates is provided, each line item's cost is instead
+// multiplied by the rate for its CurrencyCode before being summed and an error
+// is returned for any currency missing a rate.
+func (r Report) AccountSummary(s, e time.Time, rates map[string]float64) (map[string]float64, error) {
+	res := make(map[string]float64)
+	currencies := make(map[string]map[string]struct{})
+	for _, item := range r.aggregateItems(s, e) {
+		cost := item.UnblendedCost
+		if rates != nil {
+			rate, exists := rates[item.CurrencyCode]
+			if !exists {
+				return nil, fmt.Errorf("No exchange rate for currency, %s, in account, %s", item.CurrencyCode, item.UsageAccountID)
+			}
+			cost *= rate
+		}
+		res[item.UsageAccountID] += cost
+
+		if _, exists := currencies[item.UsageAccountID]; !exists {
+			currencies[item.UsageAccountID] = make(map[string]struct{})
+		}
+		currencies[item.UsageAccountID][item.CurrencyCode] = struct{}{}
+	}
+
+	if rates != nil {
+		return res, nil
+	}
+
+	accounts := make([]string, 0, len(currencies))
+	for account := range currencies {
+		accounts = append(accounts, account)
+	}
+	sort.Strings(accounts)
+	for _, account := range accounts {
+		if len(currencies[account]) < 2 {
+			continue
+		}
+		codes := make([]string, 0, len(currencies[account]))
+		for code := range currencies[account] {
+			codes = append(codes, code)
+		}
+		sort.Strings(codes)
+		return nil, fmt.Errorf("Account, %s, has line items in multiple currencies, %s", account, strings.Join(codes, ", "))
+	}
+	return res, nil
+}
+
+// SavingsPlanSavings returns the savings delivered by Savings Plans in the
+// window, summing pricing/publicOnDemandCost less
+// savingsPlan/SavingsPlanEffectiveCost over SavingsPlanCoveredUsage line items
+func (r Report) SavingsPlanSavings(s, e time.Time) float64 {
+	var savings float64
+	for _, item := range r.FilterByTime(s, e) {
+		if item.LineItemType != "SavingsPlanCoveredUsage" {
+			continue
+		}
+		savings += item.PublicOnDemandCost - item.SavingsPlanEffectiveCost
+	}
+	return savings
+}
+
+// Reconcile compares the number of line items and the summed UnblendedCost of
+// the report against expected values such as those declared by a manifest. An
+// error describing each mismatch is returned if the row count differs or the
+// totals differ by more than epsilon.
+func (r Report) Reconcile(expectedRows int, expectedTotal float64, epsilon float64) error {
+	var rows int
+	var total float64
+	for _, items := range r.LineItems {
+		rows += len(items)
+		for _, item := range items {
+			total += item.UnblendedCost
+		}
+	}
+
+	var mismatches []string
+	if rows != expectedRows {
+		mismatches = append(mismatches, fmt.Sprintf("expected %d rows but parsed %d", expectedRows, rows))
+	}
+	if math.Abs(total-expectedTotal) > epsilon {
+		mismatches = append(mismatches, fmt.Sprintf("expected total of %f but parsed %f", expectedTotal, total))
+	}
+	if len(mismatches) > 0 {
+		return fmt.Errorf("Report does not reconcile, %s", strings.Join(mismatches, ", "))
+	}
+	return nil
+}
+
+// ReconcileBlended returns the line items in the window whose BlendedCost and
+// UnblendedCost differ by more than tolerance, which points at reservations or
+// savings plans shared across the accounts of an organization
+func (r Report) ReconcileBlended(s, e time.Time, tolerance float64) []*LineItem {
+	var diff []*LineItem
+	for _, item := range r.FilterByTime(s, e) {
+		if math.Abs(item.BlendedCost-item.UnblendedCost) > tolerance {
+			diff = append(diff, item)
+		}
+	}
+	return diff
+}
+
+// Invoices returns the sorted distinct InvoiceIds of the bills in the report.
+// Line items of a single invoice can be selected by filtering on
+// bill/InvoiceId.
+func (r Report) Invoices() []string {
+	invoices := make(map[string]struct{})
+	for _, items := range r.LineItems {
+		for _, item := range items {
+			if item.Bill != nil && item.Bill.InvoiceID != "" {
+				invoices[item.Bill.InvoiceID] = struct{}{}
+			}
+		}
+	}
+	return sortedKeys(invoices)
+}
+
+// Validate returns the line items, in start order, whose identity/TimeInterval
+// does not match their lineItem/UsageStartDate and lineItem/UsageEndDate,
+// which points at a malformed export. It walks every line item so it is only
+// run when called.
+func (r Report) Validate() []*LineItem {
+	var invalid []*LineItem
+	for _, t := range r.TimePts {
+		for _, item := range r.LineItems[t] {
+			if !item.Start.Equal(item.UsageStartDate) || !item.End.Equal(item.UsageEndDate) {
+				invalid = append(invalid, item)
+			}
+		}
+	}
+	return invalid
+}
+
+// Pivot sums the UnblendedCost in the window into a two dimensional table with
+// the values of rowField down the side and the values of colField across the
+// top. Row and column labels are sorted and matrix[i][j] holds the cost for
+// rows[i] and cols[j].
+func (r Report) Pivot(rowField, colField string, s, e time.Time) (rows, cols []string, matrix [][]float64) {
+	type cell struct{ row, col string }
+	costs := make(map[cell]float64)
+	rowSet := make(map[string]struct{})
+	colSet := make(map[string]struct{})
+	items := r.aggregateItems(s, e)
+	r.warnMixedCurrencies(items)
+	for _, item := range items {
+		row, ok := r.fieldValue(item, rowField)
+		if !ok {
+			r.logf("Unsupported field to pivot by, %s\n", rowField)
+			return nil, nil, nil
+		}
+		col, ok := r.fieldValue(item, colField)
+		if !ok {
+			r.logf("Unsupported field to pivot by, %s\n", colField)
+			return nil, nil, nil
+		}
+		rowSet[row] = struct{}{}
+		colSet[col] = struct{}{}
+		costs[cell{row, col}] += item.UnblendedCost
+	}
+
+	rows = sortedKeys(rowSet)
+	cols = sortedKeys(colSet)
+	matrix = make([][]float64, len(rows))
+	for i, row := range rows {
+		matrix[i] = make([]float64, len(cols))
+		for j, col := range cols {
+			matrix[i][j] = costs[cell{row, col}]
+		}
+	}
+	return rows, cols, matrix
+}
+
+func sortedKeys(set map[string]struct{}) []string {
+	keys := make([]string, 0, len(set))
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// BillingPeriod summarizes the line items billed in one billing period
+type BillingPeriod struct {
+	Start time.Time
+	Rows  int
+	Cost  float64 // summed UnblendedCost
+}
+
+// BillingPeriods returns the distinct bill/BillingPeriodStartDate values in
+// the report with their row counts and costs, sorted by start
+func (r Report) BillingPeriods() []BillingPeriod {
+	periods := make(map[time.Time]*BillingPeriod)
+	for _, items := range r.LineItems {
+		for _, item := range items {
+			if item.Bill == nil {
+				continue
+			}
+			start := item.Bill.BillingPeriodStartDate
+			p, exists := periods[start]
+			if !exists {
+				p = &BillingPeriod{Start: start}
+				periods[start] = p
+			}
+			p.Rows++
+			p.Cost += item.UnblendedCost
+		}
+	}
+
+	res := make([]BillingPeriod, 0, len(periods))
+	for _, p := range periods {
+		res = append(res, *p)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Start.Before(res[j].Start)
+	})
+	return res
+}
+
+// EffectiveRate returns the blended cost per unit of usage, the summed
+// BlendedCost divided by the summed UsageAmount, for each value of field over
+// the window. Usage units differ between usage types so rates are only
+// comparable when field is, or is finer than, lineItem/UsageType. Groups
+// without usage are omitted.
+func (r Report) EffectiveRate(field string, s, e time.Time) map[string]float64 {
+	costs := make(map[string]float64)
+	usage := make(map[string]float64)
+	for _, item := range r.FilterByTime(s, e) {
+		key, ok := r.fieldValue(item, field)
+		if !ok {
+			r.logf("Unsupported field to group by, %s\n", field)
+			return nil
+		}
+		costs[key] += item.BlendedCost
+		usage[key] += item.UsageAmount
+	}
+
+	res := make(map[string]float64, len(costs))
+	for key, amount := range usage {
+		if amount == 0 {
+			continue
+		}
+		res[key] = costs[key] / amount
+	}
+	return res
+}
+
+// CostSplit divides cost into fixed and variable components
+type CostSplit struct {
+	Fixed    float64
+	Variable float64
+}
+
+// fixedLineItemTypes are the line item types billed regardless of usage
+var fixedLineItemTypes = map[string]struct{}{
+	"Fee":                     {}, // upfront RI fees and subscriptions
+	"RIFee":                   {},
+	"SavingsPlanRecurringFee": {},
+	"SavingsPlanUpfrontFee":   {},
+}
+
+// FixedVariableSplit sums the UnblendedCost in the window into fixed and
+// variable costs. Fee, RIFee, SavingsPlanRecurringFee and SavingsPlanUpfrontFee
+// line items and AWS Support charges are fixed. All other line items,
+// including usage, taxes, credits and refunds, are variable.
+func (r Report) FixedVariableSplit(s, e time.Time) CostSplit {
+	var split CostSplit
+	for _, item := range r.aggregateItems(s, e) {
+		_, fixed := fixedLineItemTypes[item.LineItemType]
+		if fixed || strings.HasPrefix(item.ProductCode, "AWSSupport") {
+			split.Fixed += item.UnblendedCost
+		} else {
+			split.Variable += item.UnblendedCost
+		}
+	}
+	return split
+}
+
+// NetCost is a group's gross spend, the credits against it and their sum
+type NetCost struct {
+	Gross   float64
+	Credits float64 // zero or negative
+	Net     float64
+}
+
+// GroupByNet groups the UnblendedCost in the window like GroupBy but keeps
+// gross spend and credits apart as in the Cost Explorer net unblended view.
+// Negative line items, i.e. Credit, Refund, discounts and negative RIFee
+// adjustments, count towards Credits and all others towards Gross.
+func (r Report) GroupByNet(fields []string, s, e time.Time) map[string]NetCost {
+	res := make(map[string]NetCost)
+	items := r.aggregateItems(s, e)
+	r.warnMixedCurrencies(items)
+	for _, item := range items {
+		key := r.groupKey(item, fields)
+		net := res[key]
+		if item.UnblendedCost < 0 {
+			net.Credits += item.UnblendedCost
+		} else {
+			net.Gross += item.UnblendedCost
+		}
+		net.Net = net.Gross + net.Credits
+		res[key] = net
+	}
+	return res
+}
+
+// GroupResult is the cost of a single group. Values holds the group's value
+// for each grouped field, and Key joins them with "_" as in GroupBy. Pct is
+// the group's percentage of the total cost of all groups.
+type GroupResult struct {
+	Key    string
+	Values []string
+	Cost   float64
+	Pct    float64
+}
+
+// WithPercentages sets the Pct of each result to its share of the summed cost
+// of all results and returns them. When net credits bring the total to zero
+// or below there is no meaningful share and Pct is left at 0.
+func WithPercentages(results []GroupResult) []GroupResult {
+	var total float64
+	for _, res := range results {
+		total += res.Cost
+	}
+	if total <= 0 {
+		return results
+	}
+	for i := range results {
+		results[i].Pct = 100 * results[i].Cost / total
+	}
+	return results
+}
+
+// GroupByFields groups the unblended cost in the window like GroupBy but keeps
+// each group's field values separate, aligned to the supported fields, so that
+// values containing "_" stay unambiguous. Results are sorted by their values
+// and carry their percentage of the total.
+func (r Report) GroupByFields(fields []string, s, e time.Time) []GroupResult {
+	idx := make(map[string]int)
+	var rows []GroupResult
+	var precise map[string]*big.Float
+	if r.HighPrecision {
+		precise = make(map[string]*big.Float)
+	}
+	items := r.aggregateItems(s, e)
+	r.warnMixedCurrencies(items)
+	for _, item := range items {
+		values := make([]string, 0, len(fields))
+		for _, field := range fields {
+			val, ok := r.fieldValue(item, field)
+			if !ok {
+				continue
+			}
+			values = append(values, val)
+		}
+		key := strings.Join(values, "\x00")
+		i, exists := idx[key]
+		if !exists {
+			i = len(rows)
+			idx[key] = i
+			rows = append(rows, GroupResult{Key: strings.Join(values, "_"), Values: values})
+		}
+		if precise != nil {
+			addPrecise(precise, key, item.UnblendedCost)
+		} else {
+			rows[i].Cost += item.UnblendedCost
+		}
+	}
+	for key, total := range precise {
+		rows[idx[key]].Cost, _ = total.Float64()
+	}
+
+	sort.Slice(rows, func(i, j int) bool {
+		a, b := rows[i].Values, rows[j].Values
+		for k := 0; k < len(a) && k < len(b); k++ {
+			if a[k] != b[k] {
+				return a[k] < b[k]
+			}
+		}
+		return len(a) < len(b)
+	})
+	return WithPercentages(rows)
+}
+
+// GroupByTopN groups like GroupByFields and returns the n most expensive
+// groups sorted by descending cost with ties broken by key. If n <= 0 all
+// groups are returned.
+func (r Report) GroupByTopN(fields []string, s, e time.Time, n int) []GroupResult {
+	return topN(r.GroupByFields(fields, s, e), n)
+}
+
+// OtherKey is the key of the group that small groups are rolled up into
+const OtherKey = "Other"
+
+// RollUpBelow replaces the groups whose absolute cost is below minCost with a
+// single OtherKey group holding their summed cost and percentage, so that
+// totals still reconcile. Its Values repeat OtherKey for every field. Results
+// are returned unchanged if no group is below minCost.
+func RollUpBelow(results []GroupResult, minCost float64) []GroupResult {
+	var kept []GroupResult
+	var other *GroupResult
+	for _, res := range results {
+		if math.Abs(res.Cost) >= minCost {
+			kept = append(kept, res)
+			continue
+		}
+		if other == nil {
+			other = &GroupResult{Key: OtherKey, Values: make([]string, len(res.Values))}
+			for i := range other.Values {
+				other.Values[i] = OtherKey
+			}
+		}
+		other.Cost += res.Cost
+		other.Pct += res.Pct
+	}
+	if other == nil {
+		return results
+	}
+	return append(kept, *other)
+}
+
+// topN sorts grouped results by descending cost and key and keeps the first n
+func topN(res []GroupResult, n int) []GroupResult {
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Cost != res[j].Cost {
+			return res[i].Cost > res[j].Cost
+		}
+		return res[i].Key < res[j].Key
+	})
+	if n > 0 && n < len(res) {
+		res = res[:n]
+	}
+	return res
+}
+
+// Diff groups like GroupBy over two windows and returns the change in cost of
+// each group from the first window to the second. A positive delta means
+// spend went up. Groups present in only one window are compared against zero.
+func (r Report) Diff(fields []string, s1, e1, s2, e2 time.Time) map[string]float64 {
+	delta := r.GroupBy(fields, s2, e2)
+	for key, cost := range r.GroupBy(fields, s1, e1) {
+		delta[key] -= cost
+	}
+	return delta
+}
+
+// TotalCost sums the chosen metric over the line items in the window without
+// grouping. Credits and refunds are netted and ExcludeTax, PositiveOnly and
+// HighPrecision apply exactly as in GroupByMetric, so the total matches the sum
+// of its groups.
+func (r Report) TotalCost(s, e time.Time, metric Metric) float64 {
+	if !metric.valid() {
+		r.logf("Unsupported metric to total, %s\n", metric)
+		return 0
+	}
+	items := r.aggregateItems(s, e)
+	r.warnMixedCurrencies(items)
+	var total float64
+	var precise *big.Float
+	if r.HighPrecision {
+		precise = new(big.Float).SetPrec(precision)
+	}
+	for _, item := range items {
+		v, _ := metric.Value(item)
+		if precise != nil {
+			precise.Add(precise, big.NewFloat(v))
+		} else {
+			total += v
+		}
+	}
+	if precise != nil {
+		total, _ = precise.Float64()
+	}
+	return total
+}
+
+// AggResult summarizes a metric over the line items in a group
+type AggResult struct {
+	Sum   float64
+	Count int
+	Min   float64
+	Max   float64
+	Avg   float64
+}
+
+// Aggregate groups like GroupBy but summarizes each group's line item costs in
+// a single pass over the window
+func (r Report) Aggregate(fields []string, s, e time.Time) map[string]AggResult {
+	return r.AggregateMetric(fields, s, e, MetricUnblendedCost)
+}
+
+// AggregateMetric aggregates like Aggregate but summarizes the chosen metric,
+// e.g. MetricAmortizedCost, instead of the UnblendedCost
+func (r Report) AggregateMetric(fields []string, s, e time.Time, metric Metric) map[string]AggResult {
+	if !metric.valid() {
+		r.logf("Unsupported metric to aggregate, %s\n", metric)
+		return nil
+	}
+	res := make(map[string]AggResult)
+	var precise map[string]*big.Float
+	if r.HighPrecision {
+		precise = make(map[string]*big.Float)
+	}
+	items := r.aggregateItems(s, e)
+	r.warnMixedCurrencies(items)
+	for _, item := range items {
+		key := r.groupKey(item, fields)
+		cost, _ := metric.Value(item)
+		agg, exists := res[key]
+		if !exists || cost < agg.Min {
+			agg.Min = cost
+		}
+		if !exists || cost > agg.Max {
+			agg.Max = cost
+		}
+		agg.Count++
+		if precise != nil {
+			addPrecise(precise, key, cost)
+		} else {
+			agg.Sum += cost
+		}
+		res[key] = agg
+	}
+
+	for key, agg := range res {
+		if total, exists := precise[key]; exists {
+			agg.Sum, _ = total.Float64()
+		}
+		agg.Avg = agg.Sum / float64(agg.Count)
+		res[key] = agg
+	}
+	return res
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestExcludeTax(t *testing.T) {
+	tax := testItem(2, 0, "AmazonEC2", 0.8)
+	tax.LineItemType = "Tax"
+	r := newTestReport(testItem(1, 0, "AmazonEC2", 10), tax)
+	r.EnableCache()
+
+	fields := []string{"lineItem/ProductCode"}
+	if total := r.AmortizedTotal(testStart, testEnd); total != 10.8 {
+		t.Errorf("expected a tax inclusive total of 10.8 but got %v", total)
+	}
+	if res := r.GroupBy(fields, testStart, testEnd); res["AmazonEC2"] != 10.8 {
+		t.Errorf("expected a tax inclusive group of 10.8 but got %v", res)
+	}
+
+	r.ExcludeTax = true
+	if total := r.AmortizedTotal(testStart, testEnd); total != 10 {
+		t.Errorf("expected a pre-tax total of 10 but got %v", total)
+	}
+	if res := r.GroupBy(fields, testStart, testEnd); res["AmazonEC2"] != 10 {
+		t.Errorf("expected a pre-tax group of 10 but got %v", res)
+	}
+}
+
+func TestBlendedRateColumn(t *testing.T) {
+	columns := append(append([]string(nil), testColumns...), "lineItem/BlendedRate")
+	data := testCSV(t, columns,
+		map[string]string{"lineItem/BlendedRate": "0.1", "lineItem/BlendedCost": "5", "lineItem/UnblendedCost": "5"},
+		map[string]string{"lineItem/BlendedRate": "0.1", "lineItem/BlendedCost": "4", "lineItem/UnblendedCost": "6"},
+	)
+	r := parseTestCSV(t, data)
+
+	items := r.FilterByTime(testStart, testEnd)
+	for _, item := range items {
+		// the blended rate was once read from the blended cost column
+		if item.BlendedRate != 0.1 {
+			t.Errorf("expected a blended rate of 0.1 for %s but got %v", item.LineItemID, item.BlendedRate)
+		}
+	}
+	diff := r.ReconcileBlended(testStart, testEnd, 0.01)
+	if len(diff) != 1 || diff[0].LineItemID != "id2" {
+		t.Errorf("expected only id2 to differ but got %v", diff)
+	}
+}
+
+func TestAggregate(t *testing.T) {
+	r := newTestReport(
+		testItem(1, 0, "AmazonEC2", 1),
+		testItem(2, 1, "AmazonEC2", 2),
+		testItem(3, 2, "AmazonEC2", 6),
+		testItem(4, 0, "AmazonS3", 4),
+	)
+	res := r.Aggregate([]string{"lineItem/ProductCode"}, testStart, testEnd)
+
+	expected := map[string]AggResult{
+		"AmazonEC2": {Sum: 9, Count: 3, Min: 1, Max: 6, Avg: 3},
+		"AmazonS3":  {Sum: 4, Count: 1, Min: 4, Max: 4, Avg: 4},
+	}
+	if len(res) != len(expected) {
+		t.Errorf("expected %v but got %v", expected, res)
+	}
+	for key, agg := range expected {
+		if res[key] != agg {
+			t.Errorf("expected %+v for %s but got %+v", agg, key, res[key])
+		}
+	}
+}
+
+func TestRollUpBelow(t *testing.T) {
+	r := newTestReport(
+		testItem(1, 0, "AmazonEC2", 50),
+		testItem(2, 0, "AmazonS3", 30),
+		testItem(3, 0, "AWSLambda", 0.5),
+		testItem(4, 0, "AmazonSNS", 0.25),
+		testItem(5, 0, "AmazonSQS", -0.5),
+	)
+	fields := []string{"lineItem/ProductCode"}
+	res := RollUpBelow(r.GroupByFields(fields, testStart, testEnd), 1)
+
+	if len(res) != 3 {
+		t.Fatalf("expected EC2, S3 and %s but got %+v", OtherKey, res)
+	}
+	other := res[len(res)-1]
+	if other.Key != OtherKey || other.Values[0] != OtherKey {
+		t.Fatalf("expected the last group to be %s but got %+v", OtherKey, other)
+	}
+	if other.Cost != 0.5+0.25-0.5 {
+		t.Errorf("expected %s to sum the rolled up groups to 0.25 but got %v", OtherKey, other.Cost)
+	}
+	var total, pct float64
+	for _, g := range res {
+		total += g.Cost
+		pct += g.Pct
+	}
+	if total != r.AmortizedTotal(testStart, testEnd) || math.Abs(pct-100) > 1e-9 {
+		t.Errorf("expected the groups to reconcile to the total but got %v at %v%%", total, pct)
+	}
+}
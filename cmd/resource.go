@@ -0,0 +1,250 @@
+package main
+
+import (
+	"math/big"
+	"sort"
+	"strings"
+	"time"
+)
+
+// BuildResourceIndex indexes every line item by its ResourceId so that
+// ResourceTimeline and ByResource become map lookups instead of full scans.
+// The index holds one extra pointer per line item plus a map entry per
+// distinct ResourceId, which can be significant for large reports, so it is
+// only built when explicitly requested. Once built, the index is kept up to
+// date by AddLineItem.
+func (r *Report) BuildResourceIndex() {
+	r.resourceIdx = make(map[string][]*LineItem)
+	for _, t := range r.TimePts {
+		for _, item := range r.LineItems[t] {
+			r.resourceIdx[item.ResourceID] = append(r.resourceIdx[item.ResourceID], item)
+		}
+	}
+}
+
+// indexResource adds a line item to the resource index in start order if the
+// index has been built
+func (r *Report) indexResource(l *LineItem) {
+	if r.resourceIdx == nil {
+		return
+	}
+	items := r.resourceIdx[l.ResourceID]
+	i := sort.Search(len(items), func(i int) bool {
+		return items[i].Start.After(l.Start)
+	})
+	items = append(items, nil)
+	copy(items[i+1:], items[i:])
+	items[i] = l
+	r.resourceIdx[l.ResourceID] = items
+}
+
+// ResourceTimeline returns every line item for a ResourceId sorted by start
+func (r Report) ResourceTimeline(resourceID string) []*LineItem {
+	if r.resourceIdx != nil {
+		items := r.resourceIdx[resourceID]
+		return append([]*LineItem(nil), items...)
+	}
+
+	var l []*LineItem
+	for _, t := range r.TimePts {
+		for _, item := range r.LineItems[t] {
+			if item.ResourceID == resourceID {
+				l = append(l, item)
+			}
+		}
+	}
+	return l
+}
+
+// ByResource returns the line items for a ResourceId in the window
+func (r Report) ByResource(resourceID string, s, e time.Time) []*LineItem {
+	if r.resourceIdx == nil {
+		var l []*LineItem
+		for _, item := range r.FilterByTime(s, e) {
+			if item.ResourceID == resourceID {
+				l = append(l, item)
+			}
+		}
+		return l
+	}
+
+	var l []*LineItem
+	for _, item := range r.resourceIdx[resourceID] {
+		if !r.startsBefore(item.Start, e) {
+			break
+		}
+		if item.End.After(s) {
+			l = append(l, item)
+		}
+	}
+	return l
+}
+
+// GroupByResource sums the UnblendedCost in the window per ResourceId.
+// Aggregate charges such as support, data transfer or taxes often have no
+// ResourceId, so rather than lumping them into a single empty group they are
+// labeled ProductCode:UsageType, e.g. AmazonS3:USE1-Requests-Tier1.
+func (r Report) GroupByResource(s, e time.Time) map[string]float64 {
+	res := make(map[string]float64)
+	var precise map[string]*big.Float
+	if r.HighPrecision {
+		precise = make(map[string]*big.Float)
+	}
+	items := r.aggregateItems(s, e)
+	r.warnMixedCurrencies(items)
+	for _, item := range items {
+		key := item.ResourceID
+		if key == "" {
+			key = item.ProductCode + ":" + item.UsageType
+		}
+		if precise != nil {
+			addPrecise(precise, key, item.UnblendedCost)
+		} else {
+			res[key] += item.UnblendedCost
+		}
+	}
+
+	for key, total := range precise {
+		res[key], _ = total.Float64()
+	}
+	return res
+}
+
+// TagChange is a point in a resource's timeline where its tag set changed
+type TagChange struct {
+	At         time.Time
+	Before     map[string]string
+	After      map[string]string
+	CostBefore float64 // UnblendedCost since the previous change or first line item
+	CostAfter  float64 // UnblendedCost until the next change or last line item
+}
+
+// TagChanges walks a resource's line items in start order and returns every
+// point where its tag set changed along with the cost incurred under the tags
+// before and after the change
+func (r Report) TagChanges(resourceID string) []TagChange {
+	var changes []TagChange
+	var tags map[string]string
+	var cost float64
+	for i, item := range r.ResourceTimeline(resourceID) {
+		if i > 0 && !equalTags(tags, item.Tags) {
+			if n := len(changes); n > 0 {
+				changes[n-1].CostAfter = cost
+			}
+			changes = append(changes, TagChange{
+				At:         item.Start,
+				Before:     tags,
+				After:      item.Tags,
+				CostBefore: cost,
+			})
+			cost = 0
+		}
+		tags = item.Tags
+		cost += item.UnblendedCost
+	}
+	if n := len(changes); n > 0 {
+		changes[n-1].CostAfter = cost
+	}
+	return changes
+}
+
+func equalTags(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if bv, exists := b[k]; !exists || bv != v {
+			return false
+		}
+	}
+	return true
+}
+
+// SharedResource is a logical resource whose line items span accounts
+type SharedResource struct {
+	Resource string   // ResourceId with the account removed from the ARN
+	Accounts []string // sorted UsageAccountIds the resource was billed to
+	Cost     float64
+}
+
+// logicalResource strips the account from an ARN ResourceId so the same
+// resource billed to different accounts shares a key. Non-ARN ResourceIds are
+// returned unchanged.
+func logicalResource(resourceID string) string {
+	parts := strings.SplitN(resourceID, ":", 6)
+	if len(parts) != 6 || parts[0] != "arn" {
+		return resourceID
+	}
+	parts[4] = ""
+	return strings.Join(parts, ":")
+}
+
+// SharedResources groups the line items in the window by logical resource,
+// ignoring the account portion of ARN ResourceIds, and returns the resources
+// that span more than one UsageAccountId sorted by descending cost
+func (r Report) SharedResources(s, e time.Time) []SharedResource {
+	accounts := make(map[string]map[string]struct{})
+	costs := make(map[string]float64)
+	for _, item := range r.FilterByTime(s, e) {
+		if item.ResourceID == "" {
+			continue
+		}
+		res := logicalResource(item.ResourceID)
+		if _, exists := accounts[res]; !exists {
+			accounts[res] = make(map[string]struct{})
+		}
+		accounts[res][item.UsageAccountID] = struct{}{}
+		costs[res] += item.UnblendedCost
+	}
+
+	var shared []SharedResource
+	for res, accts := range accounts {
+		if len(accts) < 2 {
+			continue
+		}
+		shared = append(shared, SharedResource{
+			Resource: res,
+			Accounts: sortedKeys(accts),
+			Cost:     costs[res],
+		})
+	}
+	sort.Slice(shared, func(i, j int) bool {
+		if shared[i].Cost != shared[j].Cost {
+			return shared[i].Cost > shared[j].Cost
+		}
+		return shared[i].Resource < shared[j].Resource
+	})
+	return shared
+}
+
+// normalizeAZ returns the line item's availability zone, labeling line items
+// without a zone as "regional" if a region can be derived from product/region
+// or the ResourceId ARN and "global" otherwise
+func normalizeAZ(l *LineItem) string {
+	if l.AvailabilityZone != "" && l.AvailabilityZone != "Not Applicable" {
+		return l.AvailabilityZone
+	}
+	if l.Region != "" {
+		return "regional"
+	}
+	if parts := strings.SplitN(l.ResourceID, ":", 6); len(parts) == 6 && parts[0] == "arn" && parts[3] != "" {
+		return "regional"
+	}
+	return "global"
+}
+
+// ResourceType returns the service and resource type of an ARN ResourceId,
+// e.g. ec2:instance for arn:aws:ec2:us-east-1:123:instance/i-abc, or just the
+// service for ARNs without a resource type such as S3 buckets. Empty is
+// returned for ResourceIds that are not ARNs.
+func (l *LineItem) ResourceType() string {
+	parts := strings.SplitN(l.ResourceID, ":", 6)
+	if len(parts) != 6 || parts[0] != "arn" {
+		return ""
+	}
+	service, resource := parts[2], parts[5]
+	if i := strings.IndexAny(resource, "/:"); i >= 0 {
+		return service + ":" + resource[:i]
+	}
+	return service
+}
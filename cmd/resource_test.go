@@ -0,0 +1,33 @@
+package main
+
+import "testing"
+
+func TestNormalizeAZ(t *testing.T) {
+	zonal := testItem(1, 0, "AmazonEC2", 1)
+	zonal.AvailabilityZone = "us-east-1a"
+	regional := testItem(2, 0, "AmazonS3", 2)
+	regional.AvailabilityZone = "Not Applicable"
+	regional.Region = "us-east-1"
+	arn := testItem(3, 0, "AWSLambda", 4)
+	arn.ResourceID = "arn:aws:lambda:us-west-2:111:function:f"
+	global := testItem(4, 0, "AmazonRoute53", 8)
+	r := newTestReport(zonal, regional, arn, global)
+
+	fields := []string{"lineItem/AvailabilityZone"}
+	raw := r.GroupBy(fields, testStart, testEnd)
+	if raw["us-east-1a"] != 1 || raw["Not Applicable"] != 2 || raw[""] != 12 {
+		t.Errorf("expected the raw zones without NormalizeAZ but got %v", raw)
+	}
+
+	r.NormalizeAZ = true
+	res := r.GroupBy(fields, testStart, testEnd)
+	expected := map[string]float64{"us-east-1a": 1, "regional": 6, "global": 8}
+	if len(res) != len(expected) {
+		t.Errorf("expected %v but got %v", expected, res)
+	}
+	for zone, cost := range expected {
+		if res[zone] != cost {
+			t.Errorf("expected %v in %s but got %v", cost, zone, res[zone])
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"math/big"
+	"strconv"
+)
+
+// DefaultDecimals is the number of decimal places costs are written with,
+// enough for the minor unit of most currencies. Rates may need more.
+const DefaultDecimals = 2
+
+// FormatDecimal formats v with exactly places decimal places, rounding half
+// away from zero on the shortest decimal representation of v so that sums such
+// as 0.1 + 0.2 = 0.30000000000000004 are written as 0.30 and 1.005 as 1.01
+func FormatDecimal(v float64, places int) string {
+	rat, ok := new(big.Rat).SetString(strconv.FormatFloat(v, 'f', -1, 64))
+	if !ok {
+		// NaN and infinities have no decimal representation
+		return strconv.FormatFloat(v, 'f', places, 64)
+	}
+	return rat.FloatString(places)
+}
+
+// RoundDecimal rounds v to places decimal places like FormatDecimal
+func RoundDecimal(v float64, places int) float64 {
+	rounded, err := strconv.ParseFloat(FormatDecimal(v, places), 64)
+	if err != nil {
+		return v
+	}
+	return rounded
+}
+
+// RoundResults returns a copy of results with each Cost and Pct rounded to
+// places decimal places for serialization
+func RoundResults(results []GroupResult, places int) []GroupResult {
+	rounded := make([]GroupResult, len(results))
+	for i, res := range results {
+		res.Cost = RoundDecimal(res.Cost, places)
+		res.Pct = RoundDecimal(res.Pct, places)
+		rounded[i] = res
+	}
+	return rounded
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestFormatDecimal(t *testing.T) {
+	tests := []struct {
+		v        float64
+		places   int
+		expected string
+	}{
+		{0.1 + 0.2, 2, "0.30"},
+		{1.005, 2, "1.01"},
+		{-1.005, 2, "-1.01"},
+		{2, 2, "2.00"},
+		{0.123456, 4, "0.1235"},
+		{math.Inf(1), 2, "+Inf"},
+	}
+	for _, test := range tests {
+		if got := FormatDecimal(test.v, test.places); got != test.expected {
+			t.Errorf("expected %v to format as %s but got %s", test.v, test.expected, got)
+		}
+	}
+	if got := RoundDecimal(0.1+0.2, 2); got != 0.3 {
+		t.Errorf("expected 0.1+0.2 to round to 0.3 but got %v", got)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// s3API is the part of the S3 client used to load reports
+type s3API interface {
+	GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error)
+}
+
+// NewReportFromS3 downloads and parses a CUR object from S3. If key is a CUR
+// *-Manifest.json every data file it lists is loaded from the same bucket into
+// one report, collecting malformed rows into a single ParseErrors naming the
+// key of each row. Credentials and the region are resolved by the AWS SDK
+// default chain, i.e. environment variables, shared config and credentials
+// files, SSO and instance roles.
+func NewReportFromS3(ctx context.Context, bucket, key string, opts ...Option) (*Report, error) {
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Could not load AWS config, %v", err)
+	}
+	return newReportFromS3(ctx, s3.NewFromConfig(cfg), bucket, key, opts...)
+}
+
+func newReportFromS3(ctx context.Context, client s3API, bucket, key string, opts ...Option) (*Report, error) {
+	body, err := getS3Object(ctx, client, bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	if !strings.HasSuffix(key, "-Manifest.json") {
+		return NewReportFromReaderContext(ctx, body, opts...)
+	}
+
+	var m curManifest
+	if err := json.NewDecoder(body).Decode(&m); err != nil {
+		return nil, fmt.Errorf("Could not parse manifest, %v", err)
+	}
+	o := newOptions(opts)
+	o.ctx = ctx
+	r := o.newReport()
+	var errs ParseErrors
+	for _, reportKey := range m.ReportKeys {
+		err := r.parseS3Object(ctx, client, bucket, reportKey, o)
+		if perrs, ok := err.(ParseErrors); ok {
+			for _, perr := range perrs {
+				perr.File = reportKey
+				errs = append(errs, perr)
+			}
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	if len(errs) > 0 {
+		return r, errs
+	}
+	return r, nil
+}
+
+// parseS3Object adds the line items of a single CUR object in S3 to the report
+func (r *Report) parseS3Object(ctx context.Context, client s3API, bucket, key string, o options) error {
+	body, err := getS3Object(ctx, client, bucket, key)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	return r.parseReader(body, o)
+}
+
+// getS3Object fetches the body of an object
+func getS3Object(ctx context.Context, client s3API, bucket, key string) (io.ReadCloser, error) {
+	out, err := client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Could not get s3://%s/%s, %v", bucket, key, err)
+	}
+	return out.Body, nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// fakeS3 serves objects from memory
+type fakeS3 map[string]string
+
+func (f fakeS3) GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	body, exists := f[aws.ToString(params.Bucket)+"/"+aws.ToString(params.Key)]
+	if !exists {
+		return nil, fmt.Errorf("NoSuchKey")
+	}
+	return &s3.GetObjectOutput{Body: ioutil.NopCloser(strings.NewReader(body))}, nil
+}
+
+func TestNewReportFromS3Manifest(t *testing.T) {
+	client := fakeS3{
+		"cur.bucket/cur/r-Manifest.json": `{"reportKeys": ["cur/r-1.csv", "cur/r-2.csv"]}`,
+		"cur.bucket/cur/r-1.csv":         testCSV(t, nil, map[string]string{"identity/LineItemId": "a"}),
+		"cur.bucket/cur/r-2.csv": testCSV(t, nil,
+			map[string]string{"identity/LineItemId": "b"},
+			map[string]string{"identity/LineItemId": "c", "lineItem/UnblendedCost": "x"},
+		),
+	}
+
+	r, err := newReportFromS3(context.Background(), client, "cur.bucket", "cur/r-Manifest.json", WithLogger(discardLogger))
+	if perrs, ok := err.(ParseErrors); !ok || len(perrs) != 1 {
+		t.Errorf("expected the malformed row of the second file to be reported but got %v", err)
+	}
+	if perrs, ok := err.(ParseErrors); ok && perrs[0].File != "cur/r-2.csv" {
+		t.Errorf("expected the error to name cur/r-2.csv but got %q", perrs[0].File)
+	}
+	if items := r.FilterByTime(testStart, testEnd); len(items) != 2 {
+		t.Errorf("expected the line items of both files but got %d", len(items))
+	}
+
+	if _, err := newReportFromS3(context.Background(), client, "cur.bucket", "cur/missing.csv"); err == nil {
+		t.Error("expected a missing object to fail")
+	}
+}
+
+// recordingTransport answers every request with body and records its URL
+type recordingTransport struct {
+	urls []string
+	body string
+}
+
+func (rt *recordingTransport) Do(req *http.Request) (*http.Response, error) {
+	rt.urls = append(rt.urls, req.URL.String())
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{},
+		Body:       ioutil.NopCloser(bytes.NewReader([]byte(rt.body))),
+		Request:    req,
+	}, nil
+}
+
+func TestNewReportFromS3DottedBucket(t *testing.T) {
+	rt := &recordingTransport{body: testCSV(t, nil, map[string]string{})}
+	client := s3.New(s3.Options{
+		Region:      "us-west-2",
+		Credentials: credentials.NewStaticCredentialsProvider("AKID", "SECRET", ""),
+		HTTPClient:  rt,
+	})
+
+	r, err := newReportFromS3(context.Background(), client, "my.cur.bucket", "cur/r-1.csv", WithLogger(discardLogger))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if items := r.FilterByTime(testStart, testEnd); len(items) != 1 {
+		t.Errorf("expected 1 line item but got %d", len(items))
+	}
+	// a bucket with dots does not match the wildcard certificate of the
+	// virtual hosted endpoint so it is addressed by path
+	if expected := "https://s3.us-west-2.amazonaws.com/my.cur.bucket/cur/r-1.csv"; len(rt.urls) != 1 || !strings.HasPrefix(rt.urls[0], expected) {
+		t.Errorf("expected a request to %s but got %v", expected, rt.urls)
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// cur2Columns maps the snake_case column names of CUR 2.0 (Data Exports) to
+// the classic CUR names the parser reads, so both export formats load into the
+// same fields
+var cur2Columns = map[string]string{
+	"identity_line_item_id":                                       "identity/LineItemId",
+	"identity_time_interval":                                      "identity/TimeInterval",
+	"bill_billing_entity":                                         "bill/Entity",
+	"bill_bill_type":                                              "bill/BillType",
+	"bill_invoice_id":                                             "bill/InvoiceId",
+	"bill_payer_account_id":                                       "bill/PayerAccountId",
+	"bill_billing_period_start_date":                              "bill/BillingPeriodStartDate",
+	"bill_billing_period_end_date":                                "bill/BillingPeriodEndDate",
+	"line_item_availability_zone":                                 "lineItem/AvailabilityZone",
+	"line_item_blended_cost":                                      "lineItem/BlendedCost",
+	"line_item_blended_rate":                                      "lineItem/BlendedRate",
+	"line_item_currency_code":                                     "lineItem/CurrencyCode",
+	"line_item_legal_entity":                                      "lineItem/LegalEntity",
+	"line_item_line_item_description":                             "lineItem/LineItemDescription",
+	"line_item_line_item_type":                                    "lineItem/LineItemType",
+	"line_item_normalization_factor":                              "lineItem/NormalizationFactor",
+	"line_item_operation":                                         "lineItem/Operation",
+	"line_item_product_code":                                      "lineItem/ProductCode",
+	"line_item_resource_id":                                       "lineItem/ResourceId",
+	"line_item_tax_type":                                          "lineItem/TaxType",
+	"line_item_unblended_cost":                                    "lineItem/UnblendedCost",
+	"line_item_unblended_rate":                                    "lineItem/UnblendedRate",
+	"line_item_usage_account_id":                                  "lineItem/UsageAccountId",
+	"line_item_usage_amount":                                      "lineItem/UsageAmount",
+	"line_item_usage_start_date":                                  "lineItem/UsageStartDate",
+	"line_item_usage_end_date":                                    "lineItem/UsageEndDate",
+	"line_item_usage_type":                                        "lineItem/UsageType",
+	"pricing_public_on_demand_cost":                               "pricing/publicOnDemandCost",
+	"product_region_code":                                         "product/region",
+	"reservation_amortized_upfront_cost_for_usage":                "reservation/AmortizedUpfrontCostForUsage",
+	"reservation_amortized_upfront_fee_for_billing_period":        "reservation/AmortizedUpfrontFeeForBillingPeriod",
+	"reservation_effective_cost":                                  "reservation/EffectiveCost",
+	"reservation_recurring_fee_for_usage":                         "reservation/RecurringFeeForUsage",
+	"reservation_reservation_a_r_n":                               "reservation/ReservationARN",
+	"reservation_unused_amortized_upfront_fee_for_billing_period": "reservation/UnusedAmortizedUpfrontFeeForBillingPeriod",
+	"reservation_unused_recurring_fee":                            "reservation/UnusedRecurringFee",
+	"savings_plan_savings_plan_effective_cost":                    "savingsPlan/SavingsPlanEffectiveCost",
+}
+
+// cur2TagsColumn is the CUR 2.0 column holding every resource tag of a line
+// item as a JSON object, replacing the per tag resourceTags/ columns. Its
+// keys are grouped on as resourceTags/<key>, e.g. resourceTags/user_team.
+const cur2TagsColumn = "resource_tags"
+
+// canonicalColumn returns the classic CUR name of a CUR 2.0 column, leaving
+// any other column unchanged
+func canonicalColumn(column string) string {
+	if classic, exists := cur2Columns[column]; exists {
+		return classic
+	}
+	return column
+}
+
+// requiredColumns are the CUR columns every line item is parsed from. Other
+// columns may be left out of custom exports and are read as empty.
+var requiredColumns = []string{
+	"identity/LineItemId",
+	"identity/TimeInterval",
+	"bill/PayerAccountId",
+	"bill/BillingPeriodStartDate",
+	"bill/BillingPeriodEndDate",
+	"lineItem/LineItemType",
+	"lineItem/Operation",
+	"lineItem/ProductCode",
+	"lineItem/UsageAccountId",
+	"lineItem/UsageType",
+}
+
+// costColumns are additionally required by exports holding each cost in its
+// own column, while costTypeColumns are required by those holding every cost
+// variant in a single cost column, one row per cost_type
+var (
+	costColumns     = []string{"lineItem/BlendedCost", "lineItem/UnblendedCost"}
+	costTypeColumns = []string{"cost", "cost_type"}
+)
+
+// checkRequiredColumns returns an error listing every required column missing
+// from the header
+func checkRequiredColumns(headerIdx map[string]int, costTypeRows bool) error {
+	required := append([]string(nil), requiredColumns...)
+	if costTypeRows {
+		required = append(required, costTypeColumns...)
+	} else {
+		required = append(required, costColumns...)
+	}
+	var missing []string
+	for _, column := range required {
+		if _, exists := headerIdx[column]; !exists {
+			missing = append(missing, column)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("Missing required columns, %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// normalizeHeaders returns a copy of a csv header row with CUR 2.0 names
+// mapped to their classic names. Files re-saved by some editors start with a
+// byte order mark, and a stray carriage return may be left on the last
+// column. csv.Reader already strips \r\n endings from the remaining rows.
+func normalizeHeaders(headers []string) []string {
+	normalized := make([]string, len(headers))
+	for i, header := range headers {
+		if i == 0 {
+			header = strings.TrimPrefix(header, byteOrderMark)
+		}
+		normalized[i] = canonicalColumn(strings.TrimRight(header, "\r"))
+	}
+	return normalized
+}
+
+// InspectHeaders reads only the header row of a CUR csv, which may be
+// gzipped, and returns its column names as the parser sees them, with CUR 2.0
+// names mapped to their classic equivalents. The decompressor is closed before
+// returning while rd is left to the caller.
+func InspectHeaders(rd io.Reader) ([]string, error) {
+	csvRd, closeFn, err := maybeGzip(rd)
+	if err != nil {
+		return nil, err
+	}
+	defer closeFn()
+
+	headers, err := csv.NewReader(csvRd).Read()
+	if err != nil {
+		return nil, fmt.Errorf("Could not read header row, %v", err)
+	}
+	return normalizeHeaders(headers), nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCUR2Columns(t *testing.T) {
+	cur2Names := make(map[string]string, len(cur2Columns))
+	for cur2, column := range cur2Columns {
+		cur2Names[column] = cur2
+	}
+	var cur2 []string
+	for _, column := range testColumns {
+		cur2 = append(cur2, cur2Names[column])
+	}
+
+	classic := testCSV(t, append(append([]string(nil), testColumns...), "resourceTags/user_team"),
+		map[string]string{"lineItem/UnblendedCost": "1.5", "resourceTags/user_team": "web"},
+		map[string]string{"lineItem/UnblendedCost": "2", "lineItem/ProductCode": "AmazonS3"},
+	)
+	data := testCSV(t, append(cur2, cur2TagsColumn),
+		map[string]string{"line_item_unblended_cost": "1.5", cur2TagsColumn: `{"user_team": "web", "user_env": ""}`},
+		map[string]string{"line_item_unblended_cost": "2", "line_item_product_code": "AmazonS3"},
+	)
+
+	fields := []string{"lineItem/ProductCode", "resourceTags/user_team"}
+	expected := parseTestCSV(t, classic).GroupByFields(fields, testStart, testEnd)
+	got := parseTestCSV(t, data).GroupByFields(fields, testStart, testEnd)
+	if len(expected) != 2 || expected[0].Key != "AmazonEC2_web" || expected[0].Cost != 1.5 {
+		t.Fatalf("expected the classic export to group EC2 under web but got %+v", expected)
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected the CUR 2.0 export to group as %+v but got %+v", expected, got)
+	}
+
+	r := parseTestCSV(t, data)
+	if tags := r.FilterByTime(testStart, testEnd)[0].Tags; len(tags) != 1 {
+		t.Errorf("expected the empty user_env tag to be dropped but got %v", tags)
+	}
+}
+
+func TestMissingRequiredColumn(t *testing.T) {
+	var columns []string
+	for _, column := range testColumns {
+		if column != "lineItem/Operation" {
+			columns = append(columns, column)
+		}
+	}
+	data := testCSV(t, columns, map[string]string{})
+
+	_, err := NewReportFromReader(strings.NewReader(data), WithLogger(discardLogger))
+	if err == nil || !strings.Contains(err.Error(), "lineItem/Operation") {
+		t.Errorf("expected an error naming the missing lineItem/Operation column but got %v", err)
+	}
+}
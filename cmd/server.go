@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Server answers grouped cost queries over HTTP from a report parsed once and
+// held in memory. The report is reparsed on SIGHUP and every ReloadEvery.
+type Server struct {
+	// MetricsFields are the fields whose values label the aws_cost_unblended
+	// gauge served on /metrics, defaulting to lineItem/ProductCode and
+	// lineItem/Operation
+	MetricsFields []string
+
+	// ReloadEvery periodically reparses the report so that the latest CUR is
+	// served, if positive
+	ReloadEvery time.Duration
+
+	filename  string
+	cachePath string
+	opts      []Option
+
+	mu     sync.RWMutex
+	report *Report
+}
+
+// NewServer parses the CUR csv at filename with opts for a new Server
+func NewServer(filename string, opts ...Option) (*Server, error) {
+	return NewServerCached(filename, "", opts...)
+}
+
+// NewServerCached is like NewServer but loads the report through the cache
+// file at cachePath as in NewReportCached, if cachePath is set
+func NewServerCached(filename, cachePath string, opts ...Option) (*Server, error) {
+	s := &Server{
+		MetricsFields: []string{"lineItem/ProductCode", "lineItem/Operation"},
+		filename:      filename,
+		cachePath:     cachePath,
+		opts:          opts,
+	}
+	if err := s.Reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Reload reparses the report, keeping the current one if parsing fails.
+// Malformed rows are logged and skipped.
+func (s *Server) Reload() error {
+	var r *Report
+	var err error
+	if s.cachePath != "" {
+		r, err = NewReportCached(s.filename, s.cachePath, s.opts...)
+	} else {
+		r, err = NewReport(s.filename, s.opts...)
+	}
+	if _, ok := err.(ParseErrors); ok {
+		logger.Println(err)
+	} else if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.report = r
+	s.mu.Unlock()
+	return nil
+}
+
+// Handler returns the routes of the server
+//
+//	GET /group?fields=lineItem/ProductCode,lineItem/Operation&start=...&end=...&top=10
+//	GET /metrics
+//	GET /healthz
+//
+// start and end are in timeLayout and default to an unbounded window. top
+// defaults to returning every group. /metrics serves the cost of the whole
+// report grouped by MetricsFields in the Prometheus text format.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/group", s.handleGroup)
+	mux.HandleFunc("/metrics", s.handleMetrics)
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("ok\n"))
+	})
+	return mux
+}
+
+// ListenAndServe serves the Handler on addr, reloading the report whenever
+// the process receives SIGHUP or ReloadEvery elapses
+func (s *Server) ListenAndServe(addr string) error {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	var tick <-chan time.Time
+	if s.ReloadEvery > 0 {
+		ticker := time.NewTicker(s.ReloadEvery)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	go func() {
+		for {
+			select {
+			case <-hup:
+			case <-tick:
+			}
+			if err := s.Reload(); err != nil {
+				logger.Printf("Could not reload report, %v\n", err)
+				continue
+			}
+			logger.Printf("Reloaded report, %s\n", s.filename)
+		}
+	}()
+	return http.ListenAndServe(addr, s.Handler())
+}
+
+func (s *Server) handleGroup(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := req.URL.Query()
+	if q.Get("fields") == "" {
+		http.Error(w, "missing fields", http.StatusBadRequest)
+		return
+	}
+	fields := strings.Split(q.Get("fields"), ",")
+	start, err := parseFlagTime(q.Get("start"), time.Time{})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	end, err := parseFlagTime(q.Get("end"), maxTime)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var top int
+	if v := q.Get("top"); v != "" {
+		if top, err = strconv.Atoi(v); err != nil {
+			http.Error(w, "invalid top, "+v, http.StatusBadRequest)
+			return
+		}
+	}
+
+	s.mu.RLock()
+	r := s.report
+	s.mu.RUnlock()
+	if err := r.ValidateQuery(fields, nil); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(r.GroupByTopN(fields, start, end, top))
+}
+
+func (s *Server) handleMetrics(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	s.mu.RLock()
+	r := s.report
+	s.mu.RUnlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	PrometheusExporter{Fields: s.MetricsFields}.Export(w, *r, time.Time{}, maxTime)
+}
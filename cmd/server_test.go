@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestServerTimeLayout(t *testing.T) {
+	const layout = "2006-01-02 15:04:05"
+	data := testCSV(t, nil,
+		map[string]string{
+			"identity/TimeInterval":       "2020-05-01 00:00:00/2020-05-01 01:00:00",
+			"bill/BillingPeriodStartDate": "2020-05-01 00:00:00",
+			"bill/BillingPeriodEndDate":   "2020-06-01 00:00:00",
+			"lineItem/UnblendedCost":      "1.5",
+		},
+	)
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "cur.csv")
+	if err := ioutil.WriteFile(filename, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cachePath := filepath.Join(dir, "cur.cache")
+	srv, err := NewServerCached(filename, cachePath, TimeLayout(layout), WithLogger(discardLogger))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/group?fields=lineItem/ProductCode", nil))
+	var res []GroupResult
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatalf("could not decode %q, %v", w.Body.String(), err)
+	}
+	if len(res) != 1 || res[0].Key != "AmazonEC2" || res[0].Cost != 1.5 {
+		t.Errorf("expected AmazonEC2 at 1.5 but got %+v", res)
+	}
+
+	if _, err := LoadReport(cachePath); err != nil {
+		t.Errorf("expected the report to be cached, %v", err)
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"database/sql"
+	"time"
+
+	_ "modernc.org/sqlite" // registers the sqlite driver
+)
+
+// sqliteDriver is the database/sql driver name registered by the pure-Go
+// modernc.org/sqlite driver used by WriteSQLite
+const sqliteDriver = "sqlite"
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS bills (
+	id                        INTEGER PRIMARY KEY,
+	billing_entity            TEXT,
+	bill_type                 TEXT,
+	invoice_id                TEXT,
+	payer_account_id          TEXT,
+	billing_period_start_date TEXT,
+	billing_period_end_date   TEXT,
+	UNIQUE (billing_entity, bill_type, invoice_id, payer_account_id, billing_period_start_date,
+		billing_period_end_date)
+);
+CREATE TABLE IF NOT EXISTS line_items (
+	uid                   INTEGER PRIMARY KEY,
+	start                 TEXT,
+	end                   TEXT,
+	availability_zone     TEXT,
+	blended_cost          REAL,
+	blended_rate          REAL,
+	currency_code         TEXT,
+	legal_entity          TEXT,
+	line_item_description TEXT,
+	line_item_type        TEXT,
+	normalization_factor  REAL,
+	operation             TEXT,
+	product_code          TEXT,
+	resource_id           TEXT,
+	tax_type              TEXT,
+	unblended_cost        REAL,
+	unblended_rate        REAL,
+	usage_account_id      TEXT,
+	usage_amount          REAL,
+	usage_start_date      TEXT,
+	usage_end_date        TEXT,
+	usage_type            TEXT,
+	bill_id               INTEGER REFERENCES bills(id)
+);
+CREATE INDEX IF NOT EXISTS line_items_product_code_start ON line_items (product_code, start);
+`
+
+// WriteSQLite writes the line items in the window and their bills to the
+// line_items and bills tables of the SQLite database at path, creating them if
+// needed. Rows are inserted in a single transaction and writing the same line
+// items again replaces them, reusing their existing bills. UIDs are stored as
+// their two's complement int64 since SQLite integers are signed.
+func (r Report) WriteSQLite(path string, s, e time.Time) error {
+	db, err := sql.Open(sqliteDriver, path)
+	if err != nil {
+		return err
+	}
+	if err = r.writeSQL(db, s, e); err != nil {
+		db.Close()
+		return err
+	}
+	return db.Close()
+}
+
+func (r Report) writeSQL(db *sql.DB, s, e time.Time) error {
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	if err = r.insertSQL(tx, s, e); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r Report) insertSQL(tx *sql.Tx, s, e time.Time) error {
+	// bills already written by an earlier run are kept and looked up so that
+	// replaced line items do not leave orphaned bills behind
+	billStmt, err := tx.Prepare(`INSERT OR IGNORE INTO bills (billing_entity, bill_type, invoice_id,
+		payer_account_id, billing_period_start_date, billing_period_end_date) VALUES (?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer billStmt.Close()
+	billIDStmt, err := tx.Prepare(`SELECT id FROM bills WHERE billing_entity = ? AND bill_type = ?
+		AND invoice_id = ? AND payer_account_id = ? AND billing_period_start_date = ?
+		AND billing_period_end_date = ?`)
+	if err != nil {
+		return err
+	}
+	defer billIDStmt.Close()
+
+	itemStmt, err := tx.Prepare(`INSERT OR REPLACE INTO line_items (uid, start, end, availability_zone,
+		blended_cost, blended_rate, currency_code, legal_entity, line_item_description, line_item_type,
+		normalization_factor, operation, product_code, resource_id, tax_type, unblended_cost,
+		unblended_rate, usage_account_id, usage_amount, usage_start_date, usage_end_date, usage_type,
+		bill_id) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer itemStmt.Close()
+
+	// line items parsed from different files carry equal but distinct Bills
+	billIDs := make(map[Bill]int64)
+	for _, item := range r.FilterByTime(s, e) {
+		var billID sql.NullInt64
+		if item.Bill != nil {
+			id, exists := billIDs[*item.Bill]
+			if !exists {
+				bill := []interface{}{
+					item.Bill.BillingEntity,
+					item.Bill.BillType,
+					item.Bill.InvoiceID,
+					item.Bill.PayerAccountID,
+					item.Bill.BillingPeriodStartDate.Format(time.RFC3339),
+					item.Bill.BillingPeriodEndDate.Format(time.RFC3339),
+				}
+				if _, err := billStmt.Exec(bill...); err != nil {
+					return err
+				}
+				if err := billIDStmt.QueryRow(bill...).Scan(&id); err != nil {
+					return err
+				}
+				billIDs[*item.Bill] = id
+			}
+			billID = sql.NullInt64{Int64: id, Valid: true}
+		}
+
+		_, err := itemStmt.Exec(
+			int64(item.UID),
+			item.Start.Format(time.RFC3339),
+			item.End.Format(time.RFC3339),
+			item.AvailabilityZone,
+			item.BlendedCost,
+			item.BlendedRate,
+			item.CurrencyCode,
+			item.LegalEntity,
+			item.LineItemDescription,
+			item.LineItemType,
+			item.NormalizationFactor,
+			item.Operation,
+			item.ProductCode,
+			item.ResourceID,
+			item.TaxType,
+			item.UnblendedCost,
+			item.UnblendedRate,
+			item.UsageAccountID,
+			item.UsageAmount,
+			item.UsageStartDate.Format(time.RFC3339),
+			item.UsageEndDate.Format(time.RFC3339),
+			item.UsageType,
+			billID,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
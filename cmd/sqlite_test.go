@@ -0,0 +1,69 @@
+package main
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteSQLite(t *testing.T) {
+	r := newTestReport(testItem(1, 0, "AmazonEC2", 1.5), testItem(2, 1, "AmazonS3", 2))
+	path := filepath.Join(t.TempDir(), "cur.db")
+
+	// a second run replaces the line items and reuses their bill
+	for i := 0; i < 2; i++ {
+		if err := r.WriteSQLite(path, testStart, testEnd); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	db, err := sql.Open(sqliteDriver, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	rows, err := db.Query(`SELECT l.uid, l.start, l.product_code, l.unblended_cost, b.invoice_id
+		FROM line_items l JOIN bills b ON b.id = l.bill_id ORDER BY l.start`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var got []*LineItem
+	for rows.Next() {
+		var uid int64
+		var start string
+		l := &LineItem{Bill: &Bill{}}
+		if err := rows.Scan(&uid, &start, &l.ProductCode, &l.UnblendedCost, &l.Bill.InvoiceID); err != nil {
+			t.Fatal(err)
+		}
+		l.UID = uint64(uid)
+		if l.Start, err = parseTime(start); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, l)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := r.FilterByTime(testStart, testEnd)
+	if len(got) != len(expected) {
+		t.Fatalf("expected %d line items but got %d", len(expected), len(got))
+	}
+	for i, l := range got {
+		e := expected[i]
+		if l.UID != e.UID || !l.Start.Equal(e.Start) || l.ProductCode != e.ProductCode ||
+			l.UnblendedCost != e.UnblendedCost || l.Bill.InvoiceID != e.Bill.InvoiceID {
+			t.Errorf("expected %+v to be read back but got %+v", e, l)
+		}
+	}
+
+	var bills int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM bills`).Scan(&bills); err != nil {
+		t.Fatal(err)
+	}
+	if bills != 1 {
+		t.Errorf("expected the shared bill to be written once but got %d", bills)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/csv"
+	"io"
+)
+
+// FilterFile streams a gzipped CUR csv from in and writes a gzipped csv to out
+// holding the header and only the rows for which keep returns true. keep is
+// passed each row keyed by column name. Rows are never parsed into line items
+// so files too large to load into a Report can be reduced.
+func FilterFile(in io.Reader, out io.Writer, keep func(fields map[string]string) bool) error {
+	gzin, err := newGzipReader(in)
+	if err != nil {
+		return err
+	}
+	defer gzin.Close()
+
+	cr := csv.NewReader(gzin)
+	cr.ReuseRecord = true
+	headers, err := cr.Read()
+	if err != nil {
+		return err
+	}
+	headers = append([]string(nil), headers...)
+
+	gzout := gzip.NewWriter(out)
+	cw := csv.NewWriter(gzout)
+	if err := cw.Write(headers); err != nil {
+		return err
+	}
+
+	fields := make(map[string]string, len(headers))
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		for i, header := range headers {
+			fields[header] = record[i]
+		}
+		if !keep(fields) {
+			continue
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return err
+	}
+	return gzout.Close()
+}
+
+// StreamReport parses line items from a CUR csv, which may be gzipped, and
+// passes each to fn without storing them. Parsing stops early once limit rows
+// have been read, if limit is positive, or as soon as fn returns false, so
+// existence checks on large files return as soon as a match is found.
+// Malformed rows are skipped and returned as ParseErrors once the stream ends.
+func StreamReport(rd io.Reader, limit int, fn func(*LineItem) bool) error {
+	csvRd, closeFn, err := maybeGzip(rd)
+	if err != nil {
+		return err
+	}
+	defer closeFn()
+
+	o := newOptions(nil)
+	o.stream = true
+	o.limit = limit
+	return scanCSV(csvRd, o, nil, fn)
+}
+
+// StreamLineItems parses line items from a CUR csv, which may be gzipped, and
+// passes each to fn without storing them, so running aggregations over very
+// large exports use bounded memory. The scan stops at the first error
+// returned by fn, which is then returned. Malformed rows are handled as in
+// NewReport.
+func StreamLineItems(rd io.Reader, fn func(*LineItem) error, opts ...Option) error {
+	csvRd, closeFn, err := maybeGzip(rd)
+	if err != nil {
+		return err
+	}
+	defer closeFn()
+
+	o := newOptions(opts)
+	o.stream = true
+	var fnErr error
+	err = scanCSV(csvRd, o, nil, func(l *LineItem) bool {
+		fnErr = fn(l)
+		return fnErr == nil
+	})
+	if fnErr != nil {
+		return fnErr
+	}
+	return err
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// costTypeTestColumns are the columns of a reshaped export holding each cost in its own row
+var costTypeTestColumns = []string{
+	"identity/LineItemId",
+	"identity/TimeInterval",
+	"bill/PayerAccountId",
+	"bill/BillingPeriodStartDate",
+	"bill/BillingPeriodEndDate",
+	"lineItem/UsageAccountId",
+	"lineItem/LineItemType",
+	"lineItem/ProductCode",
+	"lineItem/UsageType",
+	"lineItem/Operation",
+	"cost_type",
+	"cost",
+}
+
+func TestCostTypeRows(t *testing.T) {
+	// the cost rows of id1 are split around those of id2
+	data := testCSV(t, costTypeTestColumns,
+		map[string]string{"identity/LineItemId": "id1", "cost_type": "unblended", "cost": "2"},
+		map[string]string{"identity/LineItemId": "id2", "cost_type": "unblended", "cost": "3"},
+		map[string]string{"identity/LineItemId": "id1", "cost_type": "blended", "cost": "1.5"},
+	)
+
+	r := parseTestCSV(t, data)
+	items := r.FilterByTime(testStart, testEnd)
+	if len(items) != 2 {
+		t.Fatalf("expected 2 line items but got %d", len(items))
+	}
+	if items[0].LineItemID != "id1" || items[0].UnblendedCost != 2 || items[0].BlendedCost != 1.5 {
+		t.Errorf("expected id1 with unblended 2 and blended 1.5 but got %+v", items[0])
+	}
+
+	err := StreamLineItems(strings.NewReader(data), func(l *LineItem) error {
+		t.Errorf("expected no line items to be streamed but got %s", l.LineItemID)
+		return nil
+	})
+	if err == nil {
+		t.Error("expected streaming a cost_type export to fail")
+	}
+}
+
+func TestStreamReport(t *testing.T) {
+	// a plain csv whose second row is malformed
+	data := testCSV(t, nil,
+		map[string]string{},
+		map[string]string{"lineItem/UnblendedCost": "x"},
+		map[string]string{},
+		map[string]string{},
+	)
+
+	var ids []string
+	err := StreamReport(strings.NewReader(data), 0, func(l *LineItem) bool {
+		ids = append(ids, l.LineItemID)
+		return len(ids) < 2
+	})
+	if perrs, ok := err.(ParseErrors); !ok || len(perrs) != 1 || perrs[0].Row != 2 {
+		t.Errorf("expected the malformed row 2 to be returned after stopping early but got %v", err)
+	}
+	if len(ids) != 2 || ids[1] != "id3" {
+		t.Errorf("expected id1 and id3 before stopping but got %v", ids)
+	}
+
+	// the limit counts the malformed row
+	ids = nil
+	StreamReport(strings.NewReader(data), 3, func(l *LineItem) bool {
+		ids = append(ids, l.LineItemID)
+		return true
+	})
+	if len(ids) != 2 || ids[1] != "id3" {
+		t.Errorf("expected id1 and id3 in the first 3 rows but got %v", ids)
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+const day = 24 * time.Hour
+
+// clampToReport narrows the window to the span of the report's line items so
+// that a per day slice is not built for every day of an unbounded window
+func (r Report) clampToReport(s, e time.Time) (time.Time, time.Time) {
+	start, end := r.TimeRange()
+	if s.Before(start) {
+		s = start
+	}
+	if e.After(end) {
+		e = end
+	}
+	return s, e
+}
+
+// dailyTotals sums the UnblendedCost in the window per value of field and UTC
+// day. Every day from s up to e that the report spans is included so days
+// without spend are zero.
+func (r Report) dailyTotals(field string, s, e time.Time) (days []time.Time, totals map[string][]float64) {
+	s, e = r.clampToReport(s, e)
+	first := s.UTC().Truncate(day)
+	for d := first; d.Before(e); d = d.Add(day) {
+		days = append(days, d)
+	}
+
+	totals = make(map[string][]float64)
+	for _, item := range r.aggregateItems(s, e) {
+		key, ok := r.fieldValue(item, field)
+		if !ok {
+			r.logf("Unsupported field to group by, %s\n", field)
+			return days, nil
+		}
+		i := int(item.Start.UTC().Truncate(day).Sub(first) / day)
+		if i < 0 || i >= len(days) {
+			continue
+		}
+		if _, exists := totals[key]; !exists {
+			totals[key] = make([]float64, len(days))
+		}
+		totals[key][i] += item.UnblendedCost
+	}
+	return days, totals
+}
+
+// CostVolatility returns the coefficient of variation, the standard deviation
+// divided by the mean, of the daily cost per value of field over the window.
+// Groups with a zero mean daily cost are omitted.
+func (r Report) CostVolatility(field string, s, e time.Time) map[string]float64 {
+	_, totals := r.dailyTotals(field, s, e)
+	res := make(map[string]float64, len(totals))
+	for key, daily := range totals {
+		var sum float64
+		for _, cost := range daily {
+			sum += cost
+		}
+		mean := sum / float64(len(daily))
+		if mean == 0 {
+			continue
+		}
+
+		var variance float64
+		for _, cost := range daily {
+			variance += (cost - mean) * (cost - mean)
+		}
+		variance /= float64(len(daily))
+		res[key] = math.Sqrt(variance) / mean
+	}
+	return res
+}
+
+// AveragePoint is the moving average of a metric's daily totals ending on At
+type AveragePoint struct {
+	At  time.Time
+	Avg float64
+}
+
+// MovingAverage returns the trailing moving average of the daily totals of
+// metric, e.g. UnblendedCost, over the window with one point per UTC day that
+// the report spans. Each point averages its day and up to window-1 preceding
+// days, so the first days average only the days available. A window below 1 is
+// treated as 1.
+func (r Report) MovingAverage(metric string, s, e time.Time, window int) []AveragePoint {
+	if window < 1 {
+		window = 1
+	}
+
+	m := Metric(metric)
+	s, e = r.clampToReport(s, e)
+	first := s.UTC().Truncate(day)
+	var totals []float64
+	for d := first; d.Before(e); d = d.Add(day) {
+		totals = append(totals, 0)
+	}
+	for _, item := range r.aggregateItems(s, e) {
+		v, err := m.Value(item)
+		if err != nil {
+			r.logf("%v\n", err)
+			return nil
+		}
+		i := int(item.Start.UTC().Truncate(day).Sub(first) / day)
+		if i < 0 || i >= len(totals) {
+			continue
+		}
+		totals[i] += v
+	}
+
+	points := make([]AveragePoint, len(totals))
+	for i := range totals {
+		from := i - window + 1
+		if from < 0 {
+			from = 0
+		}
+		var sum float64
+		for _, total := range totals[from : i+1] {
+			sum += total
+		}
+		points[i] = AveragePoint{At: first.Add(time.Duration(i) * day), Avg: sum / float64(i+1-from)}
+	}
+	return points
+}
+
+// Spike is the single highest spend day of a service
+type Spike struct {
+	PeakDay     time.Time
+	PeakCost    float64
+	MedianDaily float64
+	Excess      float64 // PeakCost less MedianDaily
+}
+
+// SpendSpikes returns, per ProductCode, the UTC day with the highest
+// UnblendedCost in the window and how far it exceeded the service's median
+// daily cost. Days without spend within the span of the report count towards
+// the median.
+func (r Report) SpendSpikes(s, e time.Time) map[string]Spike {
+	days, totals := r.dailyTotals("lineItem/ProductCode", s, e)
+	res := make(map[string]Spike, len(totals))
+	for product, daily := range totals {
+		var spike Spike
+		for i, cost := range daily {
+			if i == 0 || cost > spike.PeakCost {
+				spike.PeakDay = days[i]
+				spike.PeakCost = cost
+			}
+		}
+		spike.MedianDaily = median(daily)
+		spike.Excess = spike.PeakCost - spike.MedianDaily
+		res[product] = spike
+	}
+	return res
+}
+
+func median(vals []float64) float64 {
+	if len(vals) == 0 {
+		return 0
+	}
+	sorted := append([]float64(nil), vals...)
+	sort.Float64s(sorted)
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 1 {
+		return sorted[mid]
+	}
+	return (sorted[mid-1] + sorted[mid]) / 2
+}
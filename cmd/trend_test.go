@@ -0,0 +1,30 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestTrendsUnboundedWindow(t *testing.T) {
+	// spend on the first and third day of the report queried without bounds
+	r := newTestReport(testItem(1, 0, "AmazonEC2", 1), testItem(2, 48, "AmazonEC2", 3))
+
+	points := r.MovingAverage("UnblendedCost", time.Time{}, maxTime, 2)
+	if len(points) != 3 || !points[0].At.Equal(testStart) {
+		t.Fatalf("expected a point for each of the 3 days of the report but got %d", len(points))
+	}
+	if points[2].Avg != 1.5 {
+		t.Errorf("expected the last 2 days to average 1.5 but got %v", points[2].Avg)
+	}
+
+	spike := r.SpendSpikes(time.Time{}, maxTime)["AmazonEC2"]
+	if spike.PeakCost != 3 || spike.MedianDaily != 1 {
+		t.Errorf("expected a peak of 3 over a median of 1 but got %+v", spike)
+	}
+
+	// daily costs of 1, 0 and 3 have a mean of 4/3 and a deviation of sqrt(14)/3
+	if vol := r.CostVolatility("lineItem/ProductCode", time.Time{}, maxTime)["AmazonEC2"]; math.Abs(vol-math.Sqrt(14)/4) > 1e-9 {
+		t.Errorf("expected a volatility of sqrt(14)/4 but got %v", vol)
+	}
+}
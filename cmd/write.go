@@ -0,0 +1,144 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/csv"
+	"io"
+	"strconv"
+	"time"
+)
+
+// curColumns are the CUR columns parsed into a LineItem in the order they are
+// written by WriteCSV
+var curColumns = []string{
+	"identity/LineItemId",
+	"identity/TimeInterval",
+	"bill/Entity",
+	"bill/BillType",
+	"bill/InvoiceId",
+	"bill/PayerAccountId",
+	"bill/BillingPeriodStartDate",
+	"bill/BillingPeriodEndDate",
+	"lineItem/AvailabilityZone",
+	"lineItem/BlendedCost",
+	"lineItem/BlendedRate",
+	"lineItem/CurrencyCode",
+	"lineItem/LegalEntity",
+	"lineItem/LineItemDescription",
+	"lineItem/LineItemType",
+	"lineItem/NormalizationFactor",
+	"lineItem/Operation",
+	"lineItem/ProductCode",
+	"lineItem/ResourceId",
+	"lineItem/TaxType",
+	"lineItem/UnblendedCost",
+	"lineItem/UnblendedRate",
+	"lineItem/UsageAccountId",
+	"lineItem/UsageAmount",
+	"lineItem/UsageStartDate",
+	"lineItem/UsageEndDate",
+	"lineItem/UsageType",
+	"pricing/publicOnDemandCost",
+	"product/region",
+	"reservation/AmortizedUpfrontCostForUsage",
+	"reservation/AmortizedUpfrontFeeForBillingPeriod",
+	"reservation/EffectiveCost",
+	"reservation/RecurringFeeForUsage",
+	"reservation/ReservationARN",
+	"reservation/UnusedAmortizedUpfrontFeeForBillingPeriod",
+	"reservation/UnusedRecurringFee",
+	"savingsPlan/SavingsPlanEffectiveCost",
+}
+
+// WriteCSV writes the line items in the window as a CUR csv holding only the
+// columns parsed by this package, followed by any resource tag columns, so
+// that it can be loaded again with NewReport once compressed
+func (r Report) WriteCSV(w io.Writer, s, e time.Time) error {
+	items := r.FilterByTime(s, e)
+
+	tagSet := make(map[string]struct{})
+	for _, item := range items {
+		for tag := range item.Tags {
+			tagSet[tag] = struct{}{}
+		}
+	}
+	tags := sortedKeys(tagSet)
+
+	cw := csv.NewWriter(w)
+	if err := cw.Write(append(append([]string(nil), curColumns...), tags...)); err != nil {
+		return err
+	}
+	for _, item := range items {
+		record := curRecord(item)
+		for _, tag := range tags {
+			record = append(record, item.Tags[tag])
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteCSVGz writes the line items in the window as a gzipped CUR csv. See
+// WriteCSV for the columns included.
+func (r Report) WriteCSVGz(w io.Writer, s, e time.Time) error {
+	gz := gzip.NewWriter(w)
+	if err := r.WriteCSV(gz, s, e); err != nil {
+		gz.Close()
+		return err
+	}
+	return gz.Close()
+}
+
+// curRecord returns the values of curColumns for a line item
+func curRecord(l *LineItem) []string {
+	b := l.Bill
+	if b == nil {
+		b = &Bill{}
+	}
+	return []string{
+		l.LineItemID,
+		l.Start.Format(timeLayout) + "/" + l.End.Format(timeLayout),
+		b.BillingEntity,
+		b.BillType,
+		b.InvoiceID,
+		b.PayerAccountID,
+		b.BillingPeriodStartDate.Format(timeLayout),
+		b.BillingPeriodEndDate.Format(timeLayout),
+		l.AvailabilityZone,
+		formatFloat(l.BlendedCost),
+		formatFloat(l.BlendedRate),
+		l.CurrencyCode,
+		l.LegalEntity,
+		l.LineItemDescription,
+		l.LineItemType,
+		formatFloat(l.NormalizationFactor),
+		l.Operation,
+		l.ProductCode,
+		l.ResourceID,
+		l.TaxType,
+		formatFloat(l.UnblendedCost),
+		formatFloat(l.UnblendedRate),
+		l.UsageAccountID,
+		formatFloat(l.UsageAmount),
+		l.UsageStartDate.Format(timeLayout),
+		l.UsageEndDate.Format(timeLayout),
+		l.UsageType,
+		formatFloat(l.PublicOnDemandCost),
+		l.Region,
+		formatFloat(l.AmortizedUpfrontCostForUsage),
+		formatFloat(l.AmortizedUpfrontFeeForBillingPeriod),
+		formatFloat(l.ReservationEffectiveCost),
+		formatFloat(l.RecurringFeeForUsage),
+		l.ReservationARN,
+		formatFloat(l.UnusedAmortizedUpfrontFeeForBillingPeriod),
+		formatFloat(l.UnusedRecurringFee),
+		formatFloat(l.SavingsPlanEffectiveCost),
+	}
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}